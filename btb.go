@@ -12,14 +12,25 @@
  * These executables will be located inside of a bin folder with a directory prefix.
  * eg. ~/.local/bin/f35/f35-firefox
  *
+ * With --shim-mode dispatcher the shims are instead symlinks to a copy
+ * of btb which then acts as the dispatcher. See pkg/dispatch. With
+ * --shim-mode symlink they are symlinks to a single launcher script.
+ *
  * Author: A.C. Minor
  * SPDX identifier: BSD-3-Clause
  */
 
 package main
 
-import "btb/cmd"
+import (
+	"btb/cmd"
+	"btb/pkg/dispatch"
+)
 
 func main() {
+	if dispatch.Invoked() {
+		cmd.ExitWithError(dispatch.Run())
+	}
+
 	cmd.Execute()
 }
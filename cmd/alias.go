@@ -0,0 +1,69 @@
+/*
+ * Aliases are executables that are symlinks to another exported one, eg.
+ * python3 -> python3.12 or cc -> gcc. With --alias-links their shims are
+ * symlinks to the shim of the executable they resolve to.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/runtime"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Returns the shim each alias shim should link to keyed by shim name
+func aliasShims(rt runtime.Runtime, allExe []string) map[string]string {
+	if !args.AliasLinks {
+		return nil
+	}
+
+	targets := shimTargets(allExe)
+	byTarget := make(map[string]string, len(targets))
+	paths := make([]string, 0, len(targets))
+	for fileName, exePath := range targets {
+		byTarget[exePath] = fileName
+		paths = append(paths, exePath)
+	}
+
+	aliases := make(map[string]string)
+	for exePath, resolved := range containerLinks(rt, paths) {
+		canonical, ok := byTarget[resolved]
+		if fileName := byTarget[exePath]; ok && canonical != fileName {
+			aliases[fileName] = canonical
+		}
+	}
+
+	return aliases
+}
+
+// Returns what each of the paths that is a symlink in the container
+// resolves to
+func containerLinks(rt runtime.Runtime, paths []string) map[string]string {
+	input := strings.NewReader(strings.Join(paths, "\n") + "\n")
+
+	lines := runScript(rt, args.Container, linkScript, input)
+	links := make(map[string]string, len(lines))
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) == 2 {
+			links[fields[0]] = fields[1]
+		}
+	}
+
+	return links
+}
+
+func writeAliasLink(filePath string, canonical string) {
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fatal(err)
+	}
+
+	if err := os.Symlink(canonical, filePath); err != nil {
+		fatal(err)
+	}
+}
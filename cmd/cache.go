@@ -0,0 +1,189 @@
+/*
+ * Cache of the executables found in a container. A scan is kept as long
+ * as the image of the container, its package databases, and its PATH
+ * directories did not change, so syncing an unchanged container skips
+ * scanning it. --force-refresh scans anyway.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/btb"
+	"btb/pkg/runtime"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/spf13/cobra"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Prints the modification time of every package database and PATH
+// directory of the container, and of the directories given as arguments,
+// eg. the search path of the runtime
+const stateScript = btb.LoginPath + `IFS=:
+for file in /usr/lib/sysimage/rpm/rpmdb.sqlite /var/lib/rpm/rpmdb.sqlite /var/lib/rpm/Packages \
+	/var/lib/dnf/history.sqlite /var/lib/dpkg/status /var/lib/pacman/local /lib/apk/db/installed \
+	$PATH "$@"; do
+	case $file in "~/"*) file=$HOME/${file#"~/"} ;; esac
+	[ -e "$file" ] && echo "$file $(stat -c %y "$file" 2>/dev/null)"
+done
+exit 0
+`
+
+type scanCache struct {
+	Key         string   `json:"key"`
+	Executables []string `json:"executables"`
+	// What shims cannot run as they are among the executables
+	Inspection *btb.Inspection `json:"inspection"`
+	// Entries of the search path the scan skipped
+	Problems []btb.Problem `json:"problems"`
+}
+
+// Changed when scans are cached with more than before, so older caches
+// are not used
+const scanCacheVersion = "4"
+
+var forceRefresh bool
+
+func addForceRefreshFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&forceRefresh, "force-refresh", "", false,
+		"scan the container even if it did not change since the last scan")
+}
+
+// Returns the modification times of what changes when executables are
+// installed or removed in the container
+func packageState(rt runtime.Runtime) (string, error) {
+	ctx, cancel := context.WithTimeout(runContext, args.Timeout)
+	defer cancel()
+
+	var scriptRuntime runtime.Runtime
+	if !insideContainer(rt, args.Container) {
+		scriptRuntime = rt
+	}
+
+	searchPath, err := btb.SearchPath(ctx, rt, args.Container)
+	if err != nil {
+		return "", err
+	}
+
+	dirs := append(searchPath, args.ScanDirs...)
+	output, err := btb.RunScript(ctx, scriptRuntime, args.Container, args.Shell, stateScript, nil, dirs...)
+	if runContext.Err() != nil {
+		interrupted()
+	}
+
+	return strings.TrimSpace(string(output)), err
+}
+
+// Returns the ID of the image of the container, empty if the runtime
+// cannot tell
+func containerImage(rt runtime.Runtime) (string, error) {
+	imager, ok := rt.(runtime.Imager)
+	if !ok || insideContainer(rt, args.Container) {
+		return "", nil
+	}
+
+	command := imager.ImageCommand(args.Container)
+	logCommand(command)
+
+	output, err := exec.CommandContext(runContext, command[0], command[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Returns what the scan of the container depends on, or false if it
+// could not be told
+func scanKey(rt runtime.Runtime) (string, bool) {
+	image, err := containerImage(rt)
+	if err != nil {
+		logDebug("not caching the scan, could not get the image of %s: %s", args.Container, err)
+		return "", false
+	}
+
+	state, err := packageState(rt)
+	if err != nil {
+		logDebug("not caching the scan, could not check %s for changes: %s", args.Container, err)
+		return "", false
+	}
+
+	hash := sha256.New()
+	for _, part := range []string{scanCacheVersion, rt.Name(), args.Container, image, state,
+		strings.Join(args.ScanDirs, "\n"), strings.Join(args.Packages, "\n")} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+func scanCachePath(rt runtime.Runtime) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if args.System {
+		cacheDir = systemCacheDir
+	}
+
+	// docker-run containers are images, eg. docker.io/library/fedora
+	return filepath.Join(cacheDir, "btb", "scan", rt.Name()+"-"+url.PathEscape(args.Container)+".json"), nil
+}
+
+// Returns the cached scan of the container if it was scanned for key
+func readScanCache(rt runtime.Runtime, key string) (*scanCache, bool) {
+	cachePath, err := scanCachePath(rt)
+	if err != nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logWarning("could not read the scan cache: %s", err)
+		}
+		return nil, false
+	}
+
+	var cache scanCache
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Key != key {
+		return nil, false
+	}
+
+	return &cache, true
+}
+
+func writeScanCache(rt runtime.Runtime, cache *scanCache) {
+	cachePath, err := scanCachePath(rt)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(cachePath), 0755)
+	}
+
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(cache)
+	}
+
+	if err == nil {
+		// written aside and renamed as watch and sync may run at once
+		tempPath := cachePath + ".tmp"
+		if err = os.WriteFile(tempPath, data, 0644); err == nil {
+			err = os.Rename(tempPath, cachePath)
+		}
+	}
+
+	if err != nil {
+		logWarning("could not write the scan cache: %s", err)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Clean command. Removes a prefix directory and its shims.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/btb"
+	"btb/pkg/dispatch"
+	"btb/pkg/manifest"
+	"btb/pkg/shim"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove a prefix directory and all of its shims",
+	Args:  cobra.NoArgs,
+	Run:   cleanCommandFunction,
+}
+
+func init() {
+	rootCmd.AddCommand(cleanCmd)
+}
+
+func cleanCommandFunction(_ *cobra.Command, _ []string) {
+	requireArgs("binpath", "prefix")
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	defer lockPrefixDir(binPath)()
+	if !dirExists(binPath) {
+		fatal(fmt.Errorf("%s does not exist", binPath))
+	}
+
+	removePrefixDir(binPath)
+}
+
+// Removes the prefix directory binPath along with what was exported for
+// its shims
+func removePrefixDir(binPath string) {
+	requireManaged(binPath)
+
+	if !manifest.Exists(binPath) {
+		if err := os.RemoveAll(binPath); err != nil {
+			fatal(err)
+		}
+		if err := manifest.Remove(binPath); err != nil {
+			fatal(err)
+		}
+
+		logInfo("Removed %s", binPath)
+		return
+	}
+
+	// only remove what btb created, leaving anything else in place
+	shimManifest := readManifest(binPath)
+	var files []string
+	for fileName := range shimManifest.Shims {
+		files = append(files, fileName)
+	}
+	files = append(files, dispatch.BinaryName, dispatch.ManifestName, shim.LauncherName,
+		manifest.LegacyFileName, btb.MarkerName)
+
+	for _, fileName := range files {
+		err := os.Remove(filepath.Join(binPath, fileName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fatal(err)
+		}
+	}
+
+	removeDesktopEntries(shimManifest, nil)
+	removeExportedFiles(shimManifest.ManPages, nil)
+	removeExportedFiles(shimManifest.Completions, nil)
+	removeExportedFiles(shimManifest.DBusServices, nil)
+
+	if err := manifest.Remove(binPath); err != nil {
+		fatal(err)
+	}
+
+	if err := os.Remove(binPath); err != nil {
+		logWarning("removed %d shims but kept %s: %s", len(shimManifest.Shims), binPath, err)
+		return
+	}
+
+	logInfo("Removed %s", binPath)
+}
@@ -0,0 +1,49 @@
+/*
+ * Completion command. Generates the shell completion of btb itself. See
+ * the completions command for the completions of the shims.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "Generate the completion script of btb for a shell",
+	Long: `Generate the completion script of btb for a shell, eg.
+  bash: btb completion bash > ~/.local/share/bash-completion/completions/btb
+  zsh:  btb completion zsh > "${fpath[1]}/_btb"
+  fish: btb completion fish > ~/.config/fish/completions/btb.fish`,
+	Args:                  cobra.ExactValidArgs(1),
+	ValidArgs:             []string{"bash", "zsh", "fish"},
+	DisableFlagsInUseLine: true,
+	// does not need the config file
+	PersistentPreRun: func(*cobra.Command, []string) {},
+	Run:              completionCommandFunction,
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
+
+func completionCommandFunction(_ *cobra.Command, cmdArgs []string) {
+	var err error
+	switch cmdArgs[0] {
+	case "bash":
+		err = rootCmd.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		err = rootCmd.GenZshCompletion(os.Stdout)
+	case "fish":
+		err = rootCmd.GenFishCompletion(os.Stdout, true)
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * Completion export command. Copies the completion files the packages of
+ * the container install for the exported executables and rewrites them
+ * for the shim names, so completing does not call into the container
+ * like the completions of the completions command do.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var completionExportCmd = &cobra.Command{
+	Use:   "completion-export",
+	Short: "Copy the completion files of the executables in a container",
+	Long: `Copy the bash, zsh, and fish completion files of the executables of the
+prefix's shims out of the container, rewritten for the shim names. Sync first.
+They go where the completions command puts its completions and replace those.
+With --live-fallback shims without completion files get the completions of
+the completions command. Completions that run the executable itself only work
+if it is installed on the host too.`,
+	Args: cobra.NoArgs,
+	Run:  completionExportCommandFunction,
+}
+
+var completionLiveFallback bool
+
+func init() {
+	completionExportCmd.Flags().StringSliceVarP(&completionShells, "shell", "", []string{"bash", "zsh", "fish"},
+		"shells to export completions for (bash, zsh, fish)")
+	completionExportCmd.Flags().BoolVarP(&completionLiveFallback, "live-fallback", "", false,
+		"export completions asking the container for shims without completion files")
+
+	rootCmd.AddCommand(completionExportCmd)
+}
+
+// Writes a tar archive of the completion files of the executables named
+// by the arguments to stdout, or nothing if there are none, those in
+// /usr/local first
+const completionFilesScript = `for name; do
+	for file in \
+		"usr/local/share/bash-completion/completions/$name" \
+		"usr/share/bash-completion/completions/$name" \
+		"usr/local/share/zsh/site-functions/_$name" \
+		"usr/share/zsh/site-functions/_$name" \
+		"usr/share/zsh/vendor-completions/_$name" \
+		"usr/local/share/fish/vendor_completions.d/$name.fish" \
+		"usr/share/fish/vendor_completions.d/$name.fish" \
+		"usr/share/fish/completions/$name.fish"; do
+		[ -f "/$file" ] && set -- "$@" "$file"
+	done
+	shift
+done
+[ $# -eq 0 ] && exit 0
+cd / && exec tar -chf - -- "$@"
+`
+
+func completionExportCommandFunction(cmd *cobra.Command, _ []string) {
+	requireUserInstall(cmd)
+	requireArgs("binpath", "prefix", "container")
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	defer lockPrefixDir(binPath)()
+	if !isManagedDir(binPath) || !manifest.Exists(binPath) {
+		fatal(fmt.Errorf("%s has no shims to export completions for, run sync first", binPath))
+	}
+
+	rt := containerRuntime()
+	completions := completionDirs()
+	shimManifest := readManifest(binPath)
+
+	shimsByExe := make(map[string][]string)
+	for fileName, entry := range shimManifest.Shims {
+		if entry.Container == args.Container {
+			exe := filepath.Base(entry.Target)
+			shimsByExe[exe] = append(shimsByExe[exe], fileName)
+		}
+	}
+
+	exes := make([]string, 0, len(shimsByExe))
+	for exe := range shimsByExe {
+		exes = append(exes, exe)
+	}
+	sort.Strings(exes)
+
+	removeExportedFiles(shimManifest.Completions, nil)
+	for _, dirPath := range completions {
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			fatal(err)
+		}
+	}
+
+	var archive []byte
+	if len(exes) != 0 {
+		archive = runScriptOutput(rt, args.Container, completionFilesScript, nil, exes...)
+	}
+
+	// the first file of every shell and executable, keyed by shell and name
+	found := make(map[string]bool)
+	exported := make(map[string]string)
+	forEachFile(archive, func(name string, data []byte) {
+		shell, exe := completionFileExe(name)
+		dirPath, ok := completions[shell]
+		if !ok || found[shell+"/"+exe] {
+			return
+		}
+		found[shell+"/"+exe] = true
+
+		for _, fileName := range shimsByExe[exe] {
+			filePath := filepath.Join(dirPath, completionFileName(shell, fileName))
+			replaceFile(filePath, rewriteCompletion(shell, data, exe, fileName))
+			exported[filePath] = fileName
+		}
+	})
+	copied := len(exported)
+
+	if completionLiveFallback {
+		for shell, dirPath := range completions {
+			for _, exe := range exes {
+				if found[shell+"/"+exe] {
+					continue
+				}
+
+				for _, fileName := range shimsByExe[exe] {
+					filePath, contents := renderCompletion(rt, shell, fileName, exe)
+					filePath = filepath.Join(dirPath, filePath)
+					replaceFile(filePath, []byte(contents))
+					exported[filePath] = fileName
+				}
+			}
+		}
+	}
+
+	shimManifest.Completions = exported
+	if err := shimManifest.Write(binPath); err != nil {
+		fatal(err)
+	}
+
+	logInfo("Exported %d completion files and %d live completions", copied, len(exported)-copied)
+}
+
+// Returns the shell and executable of a completion file of the archive,
+// eg. zsh and rg for usr/share/zsh/site-functions/_rg
+func completionFileExe(name string) (string, string) {
+	base := filepath.Base(name)
+	switch {
+	case strings.Contains(name, "/bash-completion/"):
+		return "bash", base
+	case strings.Contains(name, "/zsh/"):
+		return "zsh", strings.TrimPrefix(base, "_")
+	case strings.Contains(name, "/fish/"):
+		return "fish", strings.TrimSuffix(base, ".fish")
+	}
+
+	return "", base
+}
+
+// Rewrites the completion file of exe for shell so that it completes the
+// shim named fileName instead
+func rewriteCompletion(shell string, data []byte, exe string, fileName string) []byte {
+	if exe == fileName {
+		return data
+	}
+
+	// exe as a word on the lines registering completions, for fish only
+	// after -c or --command since the rest of its lines are descriptions
+	word := regexp.MustCompile(`(^|[ \t])` + regexp.QuoteMeta(exe) + `([ \t]|$)`)
+	if shell == "fish" {
+		word = regexp.MustCompile(`((?:^|[ \t])(?:-c|--command)(?:[ \t]+|=))` + regexp.QuoteMeta(exe) + `([ \t]|$)`)
+	}
+	replacement := "${1}" + strings.ReplaceAll(fileName, "$", "$$") + "${2}"
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		command := strings.TrimSpace(line)
+		if strings.HasPrefix(command, "complete ") || strings.HasPrefix(command, "#compdef ") ||
+			strings.HasPrefix(command, "compdef ") {
+			lines[i] = word.ReplaceAllString(line, replacement)
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
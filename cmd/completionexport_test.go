@@ -0,0 +1,24 @@
+package cmd
+
+import "testing"
+
+func TestRewriteCompletion(t *testing.T) {
+	tests := []struct {
+		shell string
+		data  string
+		want  string
+	}{
+		{"bash", "_rg() {\n\trg --help\n}\ncomplete -F _rg -o bashdefault rg\n",
+			"_rg() {\n\trg --help\n}\ncomplete -F _rg -o bashdefault f39-rg\n"},
+		{"zsh", "#compdef rg\n_rg() { _arguments '--rg' }\n", "#compdef f39-rg\n_rg() { _arguments '--rg' }\n"},
+		{"fish", "complete -c rg -l files -d 'list the files rg would search'\ncomplete --command=rg -s h\n",
+			"complete -c f39-rg -l files -d 'list the files rg would search'\ncomplete --command=f39-rg -s h\n"},
+		{"bash", "complete -F _rgx rgx\n", "complete -F _rgx rgx\n"},
+	}
+
+	for _, test := range tests {
+		if got := string(rewriteCompletion(test.shell, []byte(test.data), "rg", "f39-rg")); got != test.want {
+			t.Errorf("%s completion\n%s\nis rewritten as\n%s\nwant\n%s", test.shell, test.data, got, test.want)
+		}
+	}
+}
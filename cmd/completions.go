@@ -0,0 +1,188 @@
+/*
+ * Completions command. Generates bash, zsh, and fish completions for the
+ * shims that ask bash-completion inside of the container for the
+ * completions of the executable they run.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"btb/pkg/shim"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var completionsCmd = &cobra.Command{
+	Use:   "completions",
+	Short: "Export shell completions for the shims of a container",
+	Long: `Export shell completions for the shims of a container.
+Every completion runs bash-completion inside of the container, so it needs
+bash and bash-completion there. Sync first. Completions are written to
+  bash: $XDG_DATA_HOME/bash-completion/completions
+  zsh:  $XDG_DATA_HOME/zsh/site-functions, which has to be on fpath
+  fish: $XDG_CONFIG_HOME/fish/completions`,
+	Args: cobra.NoArgs,
+	Run:  completionsCommandFunction,
+}
+
+var completionShells []string
+
+func init() {
+	completionsCmd.Flags().StringSliceVarP(&completionShells, "shell", "", []string{"bash", "zsh", "fish"},
+		"shells to export completions for (bash, zsh, fish)")
+
+	rootCmd.AddCommand(completionsCmd)
+}
+
+// Prints the completions of the command line given as the executable,
+// the index of the word to complete, and the words after the executable
+const completeScript = `[ -r /usr/share/bash-completion/bash_completion ] || exit 0
+. /usr/share/bash-completion/bash_completion
+exe=$1 COMP_CWORD=$2
+shift 2
+COMP_WORDS=("$exe" "$@")
+COMP_LINE=${COMP_WORDS[*]}
+COMP_POINT=${#COMP_LINE}
+__load_completion "$exe" || _completion_loader "$exe"
+spec=$(complete -p "$exe") || exit 0
+[[ $spec =~ -F\ ([^ ]+) ]] || exit 0
+"${BASH_REMATCH[1]}" "$exe" "${COMP_WORDS[COMP_CWORD]}" "${COMP_WORDS[COMP_CWORD-1]}"
+printf '%s\n' "${COMPREPLY[@]}"
+`
+
+// Completions of every shell filled in with the shim name, a function
+// name, and the command printing the completions
+const bashCompletion = `# Generated by btb
+%[2]s() {
+	local IFS=$'\n'
+	COMPREPLY=($(%[3]s "$COMP_CWORD" "${COMP_WORDS[@]:1}" 2>/dev/null))
+}
+complete -o default -F %[2]s %[1]s
+`
+
+const zshCompletion = `#compdef %[1]s
+# Generated by btb
+local -a replies
+replies=("${(@f)$(%[3]s $((CURRENT - 1)) "${(@)words[2,-1]}" 2>/dev/null)}")
+if [[ -n $replies[1] ]]; then
+	compadd -a replies
+else
+	_files
+fi
+`
+
+const fishCompletion = `# Generated by btb
+function %[2]s
+	set -l words (commandline -opc)
+	set -l current (commandline -ct)
+	%[3]s (count $words) $words[2..-1] "$current" 2>/dev/null
+end
+complete -c %[1]s -a '(%[2]s)'
+`
+
+func completionsCommandFunction(cmd *cobra.Command, _ []string) {
+	requireUserInstall(cmd)
+	requireArgs("binpath", "prefix", "container")
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	defer lockPrefixDir(binPath)()
+	if !isManagedDir(binPath) || !manifest.Exists(binPath) {
+		fatal(fmt.Errorf("%s has no shims to export completions for, run sync first", binPath))
+	}
+
+	rt := containerRuntime()
+
+	completions := completionDirs()
+	shimManifest := readManifest(binPath)
+	removeExportedFiles(shimManifest.Completions, nil)
+
+	exported := make(map[string]string)
+	for shell, dirPath := range completions {
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			fatal(err)
+		}
+
+		for fileName, entry := range shimManifest.Shims {
+			if entry.Container != args.Container {
+				continue
+			}
+
+			filePath, contents := renderCompletion(rt, shell, fileName, filepath.Base(entry.Target))
+			filePath = filepath.Join(dirPath, filePath)
+			replaceFile(filePath, []byte(contents))
+			exported[filePath] = fileName
+		}
+	}
+
+	shimManifest.Completions = exported
+	if err := shimManifest.Write(binPath); err != nil {
+		fatal(err)
+	}
+
+	logInfo("Exported %d completions", len(exported))
+}
+
+// Returns the directory the completions of every shell given with
+// --shell go in keyed by shell
+func completionDirs() map[string]string {
+	completions := make(map[string]string)
+	for _, shell := range completionShells {
+		switch shell {
+		case "bash":
+			completions[shell] = filepath.Join(dataHome(), "bash-completion", "completions")
+		case "zsh":
+			completions[shell] = filepath.Join(dataHome(), "zsh", "site-functions")
+		case "fish":
+			configDir, err := os.UserConfigDir()
+			if err != nil {
+				fatal(err)
+			}
+			completions[shell] = filepath.Join(configDir, "fish", "completions")
+		default:
+			fatal(fmt.Errorf("unknown shell %q (bash, zsh, fish)", shell))
+		}
+	}
+
+	return completions
+}
+
+// Returns the file name of the completion of a shim for shell
+func completionFileName(shell string, fileName string) string {
+	switch shell {
+	case "zsh":
+		return "_" + fileName
+	case "fish":
+		return fileName + ".fish"
+	default:
+		return fileName
+	}
+}
+
+// Returns the file name and contents of the completion of a shim
+func renderCompletion(rt runtime.Runtime, shell string, fileName string, exe string) (string, string) {
+	function := "__btb_" + strings.Map(func(char rune) rune {
+		if char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' {
+			return char
+		}
+		return '_'
+	}, fileName)
+	command := shim.QuoteAll(rt.Command(args.Container, "bash", "-c", completeScript, "bash", exe))
+
+	completion := bashCompletion
+	switch shell {
+	case "zsh":
+		completion = zshCompletion
+	case "fish":
+		completion = fishCompletion
+	}
+
+	return completionFileName(shell, fileName), fmt.Sprintf(completion, fileName, function, command)
+}
@@ -0,0 +1,123 @@
+/*
+ * Conflicts between shims and the commands on the host. A shim named like
+ * a host command shadows it, or is shadowed by it, depending on the order
+ * of PATH, which --on-conflict decides what to do about.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Returns the host command every file name in targets conflicts with,
+// ignoring the directories btb manages
+func hostConflicts(targets map[string]string) map[string]string {
+	conflicts := make(map[string]string)
+	for _, dir := range strings.Split(os.Getenv("PATH"), ":") {
+		if dir == "" || !dirExists(dir) || isManagedDir(dir) {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			logWarning("%s", err)
+			continue
+		}
+
+		for _, entry := range entries {
+			fileName := entry.Name()
+			if _, ok := targets[fileName]; !ok {
+				continue
+			}
+			if _, ok := conflicts[fileName]; ok {
+				continue
+			}
+
+			hostPath := filepath.Join(dir, fileName)
+			if info, err := os.Stat(hostPath); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				conflicts[fileName] = hostPath
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// Leaves out the executables of allExe named like a command on the host
+// PATH with --skip-host-duplicates, whatever their shims are named
+func withoutHostDuplicates(allExe []string) []string {
+	if !args.SkipHostDups {
+		return allExe
+	}
+
+	names := make(map[string]string, len(allExe))
+	for _, exePath := range allExe {
+		names[filepath.Base(exePath)] = exePath
+	}
+	duplicates := hostConflicts(names)
+
+	kept := make([]string, 0, len(allExe))
+	for _, exePath := range allExe {
+		if hostPath, ok := duplicates[filepath.Base(exePath)]; ok {
+			summary.omit(exePath, "the host has it at "+hostPath)
+			continue
+		}
+		kept = append(kept, exePath)
+	}
+	if skipped := len(allExe) - len(kept); skipped != 0 {
+		logInfo("Skipping %d executables the host has too", skipped)
+	}
+
+	return kept
+}
+
+// Applies --on-conflict and returns allExe without the executables whose
+// shims are skipped
+func checkConflicts(allExe []string) []string {
+	if args.OnConflict == "overwrite" {
+		return allExe
+	}
+
+	targets := shimTargets(allExe)
+	conflicts := hostConflicts(targets)
+
+	fileNames := make([]string, 0, len(conflicts))
+	for fileName := range conflicts {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	skipped := make(map[string]bool)
+	for _, fileName := range fileNames {
+		if args.OnConflict == "skip" {
+			logInfo("Skipping %s, a command with the same name is at %s", fileName, conflicts[fileName])
+			skipped[filepath.Base(targets[fileName])] = true
+			summary.Skipped++
+			summary.omit(targets[fileName], "same name as "+conflicts[fileName])
+		} else {
+			logWarning("%s has the same name as %s", fileName, conflicts[fileName])
+		}
+	}
+
+	if len(skipped) == 0 {
+		return allExe
+	}
+
+	// by name so that a shadowed executable does not take the place of a
+	// skipped one
+	var kept []string
+	for _, exePath := range allExe {
+		if !skipped[filepath.Base(exePath)] {
+			kept = append(kept, exePath)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,192 @@
+/*
+ * Containers known to a runtime.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/runtime"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Returns the names of the containers of rt. Returns false for runtimes
+// that cannot list them.
+func listContainers(rt runtime.Runtime) ([]string, bool, error) {
+	lister, ok := rt.(runtime.Lister)
+	if !ok {
+		return nil, false, nil
+	}
+
+	command := lister.ListCommand()
+	logCommand(command)
+	output, err := exec.CommandContext(runContext, command[0], command[1:]...).Output()
+	if err != nil {
+		return nil, true, fmt.Errorf("listing the containers of %s: %w", rt.Name(), err)
+	}
+
+	var containers []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			containers = append(containers, line)
+		}
+	}
+
+	return containers, true, nil
+}
+
+// Returns the runtime given by --runtime after checking that it has the
+// container given by --container
+func containerRuntime() runtime.Runtime {
+	rt, err := findRuntime()
+	if err != nil {
+		fatal(err)
+	}
+
+	return rt
+}
+
+// Like containerRuntime but returns what is wrong
+func findRuntime() (runtime.Runtime, error) {
+	rt, err := runtime.Get(args.Runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	_, remote := rt.(runtime.Remote)
+	name, inside := currentContainer()
+	if inside && !remote {
+		if name != "" && args.Container != "" && name != args.Container {
+			return nil, fmt.Errorf("btb is running inside of container %s but --container is %s, "+
+				"run it on the host or inside of %s", name, args.Container, args.Container)
+		}
+
+		// scan the container directly, the runtime is outside of it
+		args.InContainer = true
+		return rt, nil
+	}
+
+	if err := authenticateRoot(rt); err != nil {
+		return nil, err
+	}
+	if err := checkContainer(rt, args.Container); err != nil {
+		return nil, err
+	}
+
+	return rt, nil
+}
+
+// Returns the name of the container btb is running in, which is empty if
+// the container does not say, or false if it is running on the host
+func currentContainer() (string, bool) {
+	// written by podman, and so toolbox and distrobox, eg. name="f39"
+	if data, err := os.ReadFile("/run/.containerenv"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "name=") {
+				name, err := strconv.Unquote(strings.TrimPrefix(line, "name="))
+				if err == nil {
+					return name, true
+				}
+			}
+		}
+		return "", true
+	}
+
+	for _, path := range []string{"/run/.toolboxenv", "/.dockerenv"} {
+		if _, err := os.Stat(path); err == nil {
+			return "", true
+		}
+	}
+
+	return "", false
+}
+
+// Returns an error listing the containers of rt if container is not one
+// of them
+func checkContainer(rt runtime.Runtime, container string) error {
+	// also finds what is not listed, eg. images by ID for docker-run
+	if checker, ok := rt.(runtime.Checker); ok {
+		command := checker.ExistsCommand(container)
+		logCommand(command)
+		if exec.CommandContext(runContext, command[0], command[1:]...).Run() == nil {
+			return nil
+		}
+	}
+
+	containers, ok, err := listContainers(rt)
+	if err != nil || !ok {
+		return err
+	}
+
+	for _, name := range containers {
+		if name == container {
+			return nil
+		}
+	}
+
+	return unknownContainerError(rt, container, containers)
+}
+
+func unknownContainerError(rt runtime.Runtime, container string, containers []string) error {
+	if len(containers) == 0 {
+		return &classError{fmt.Errorf("there is no %s container %s, there are no containers yet", rt.Name(), container),
+			errNoContainer}
+	}
+
+	message := fmt.Sprintf("there is no %s container %s", rt.Name(), container)
+	if similar := similarNames(container, containers); len(similar) != 0 {
+		message += fmt.Sprintf(", did you mean %s?", strings.Join(similar, " or "))
+	}
+
+	return &classError{fmt.Errorf("%s\navailable containers: %s", message, strings.Join(containers, ", ")),
+		errNoContainer}
+}
+
+// Returns the names that are a typo of name away or contain its
+// characters in order, eg. fedora-toolbox-39 for f39
+func similarNames(name string, names []string) []string {
+	var similar []string
+	for _, candidate := range names {
+		if editDistance(name, candidate) <= 2 || len(name) >= 3 && fuzzyMatch(name, candidate) {
+			similar = append(similar, candidate)
+		}
+	}
+
+	return similar
+}
+
+// Returns the Levenshtein distance of a and b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = current[j-1] + 1
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
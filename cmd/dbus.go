@@ -0,0 +1,126 @@
+/*
+ * D-Bus services. With desktop --dbus-services the session bus services
+ * of the container are exported with their Exec= lines pointing at the
+ * shims, so applications started by D-Bus activation, eg. a text editor
+ * opening a file for the file manager, run in the container too.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/runtime"
+	"btb/pkg/shim"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prints every session bus service in the container, each preceded by a
+// line with its path after a \001
+const dbusServicesScript = `for file in /usr/share/dbus-1/services/*.service; do
+	[ -f "$file" ] || continue
+	printf '\001%s\n' "$file"
+	cat "$file"
+done
+exit 0
+`
+
+// Directories the host has session bus services in, which win over the
+// exported ones of the same name
+var hostDBusServiceDirs = []string{"/usr/share/dbus-1/services", "/usr/local/share/dbus-1/services"}
+
+// Exports the services of the container that run a shim found by resolve
+// into $XDG_DATA_HOME/dbus-1/services and returns the shim each is for
+// keyed by its path
+func exportDBusServices(rt runtime.Runtime, resolve func(string) string) map[string]string {
+	servicePath := filepath.Join(dataHome(), "dbus-1", "services")
+	if err := os.MkdirAll(servicePath, 0755); err != nil {
+		fatal(err)
+	}
+
+	exported := make(map[string]string)
+	sources, services := splitDesktopEntries(runScript(rt, args.Container, dbusServicesScript, nil))
+	for _, source := range sources {
+		lines, shimPath := rewriteDBusService(services[source], resolve)
+		if shimPath == "" {
+			logVerbose("Skipping %s, it does not run an exported executable", source)
+			continue
+		}
+
+		// the file name is the bus name, so it can only be exported once
+		fileName := filepath.Base(source)
+		if hostDBusService(fileName) {
+			logVerbose("Skipping %s, the host has it", source)
+			continue
+		}
+
+		filePath := filepath.Join(servicePath, fileName)
+		if _, err := os.Lstat(filePath); err == nil {
+			logWarning("%s exists, not exporting %s", filePath, source)
+			continue
+		}
+
+		replaceFile(filePath, []byte(strings.Join(lines, "\n")+"\n"))
+		exported[filePath] = filepath.Base(shimPath)
+	}
+
+	return exported
+}
+
+// Reports if the host has a session bus service of the same file name
+func hostDBusService(fileName string) bool {
+	for _, dir := range hostDBusServiceDirs {
+		if _, err := os.Stat(filepath.Join(dir, fileName)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Rewrites the Exec= line of a D-Bus service to run the shim found by
+// resolve. Returns the shim, or an empty string if there is none.
+func rewriteDBusService(lines []string, resolve func(string) string) ([]string, string) {
+	var rewritten []string
+	var shimPath string
+
+	var group string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			group = trimmed[1 : len(trimmed)-1]
+			rewritten = append(rewritten, line)
+			continue
+		}
+
+		equals := strings.IndexByte(line, '=')
+		if equals < 0 || group != "D-BUS Service" {
+			rewritten = append(rewritten, line)
+			continue
+		}
+
+		key, value := strings.TrimSpace(line[:equals]), strings.TrimSpace(line[equals+1:])
+		switch key {
+		case "Exec":
+			fields := strings.SplitN(value, " ", 2)
+			if shimPath = resolve(strings.Trim(fields[0], `"'`)); shimPath == "" {
+				return nil, ""
+			}
+
+			line = "Exec=" + shim.Quote(shimPath)
+			if len(fields) == 2 {
+				line += " " + fields[1]
+			}
+		case "SystemdService":
+			// a unit of the container, the host does not have it
+			continue
+		}
+
+		rewritten = append(rewritten, line)
+	}
+
+	return rewritten, shimPath
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteDBusService(t *testing.T) {
+	resolve := func(command string) string {
+		if command == "/usr/bin/gedit" {
+			return "/home/user/.local/bin/f35/gedit"
+		}
+		return ""
+	}
+
+	lines, shimPath := rewriteDBusService([]string{
+		"[D-BUS Service]",
+		"Name=org.gnome.gedit",
+		"Exec=/usr/bin/gedit --gapplication-service",
+		"SystemdService=gedit.service",
+	}, resolve)
+	want := "[D-BUS Service]\nName=org.gnome.gedit\nExec=/home/user/.local/bin/f35/gedit --gapplication-service"
+	if shimPath != "/home/user/.local/bin/f35/gedit" || strings.Join(lines, "\n") != want {
+		t.Errorf("rewritten service runs %s:\n%s", shimPath, strings.Join(lines, "\n"))
+	}
+
+	if _, shimPath := rewriteDBusService([]string{"[D-BUS Service]", "Exec=/usr/libexec/evolution-source-registry"},
+		resolve); shimPath != "" {
+		t.Errorf("service without a shim runs %s", shimPath)
+	}
+}
@@ -0,0 +1,410 @@
+/*
+ * Desktop command. Exports the desktop entries of a container's
+ * applications to the host with their Exec= lines pointing at the shims
+ * so they show up in the launcher of the desktop environment.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var desktopCmd = &cobra.Command{
+	Use:   "desktop",
+	Short: "Export the desktop entries of the applications in a container",
+	Long: `Export the desktop entries of the applications in a container.
+Entries from /usr/share/applications in the container are rewritten to run
+the shims of the prefix, so sync first, and installed into
+$XDG_DATA_HOME/applications named after the prefix. Their icons are copied
+from the container's hicolor theme into $XDG_DATA_HOME/icons. With
+--mime-defaults the applications become the default for the file types they
+open in mimeapps.list, until their entries are removed again. With
+--dbus-services the session bus services of the container that run shims are
+exported into $XDG_DATA_HOME/dbus-1/services, except those the host has.`,
+	Args: cobra.NoArgs,
+	Run:  desktopCommandFunction,
+}
+
+var (
+	desktopMimeDefaults bool
+	desktopDBusServices bool
+)
+
+func init() {
+	desktopCmd.Flags().BoolVarP(&desktopMimeDefaults, "mime-defaults", "", false,
+		"make the applications the default for the file types they open")
+	desktopCmd.Flags().BoolVarP(&desktopDBusServices, "dbus-services", "", false,
+		"export the D-Bus services of the container that start the applications")
+
+	rootCmd.AddCommand(desktopCmd)
+}
+
+// Prints every desktop entry in the container, each preceded by a line
+// with its path after a \001
+const desktopScript = `for file in /usr/share/applications/*.desktop; do
+	[ -f "$file" ] || continue
+	printf '\001%s\n' "$file"
+	cat "$file"
+done
+exit 0
+`
+
+func desktopCommandFunction(cmd *cobra.Command, _ []string) {
+	requireUserInstall(cmd)
+	requireArgs("binpath", "prefix", "container")
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	defer lockPrefixDir(binPath)()
+	if !isManagedDir(binPath) || !manifest.Exists(binPath) {
+		fatal(fmt.Errorf("%s has no shims to export desktop entries for, run sync first", binPath))
+	}
+
+	rt := containerRuntime()
+
+	shimManifest := readManifest(binPath)
+	resolve := shimResolver(binPath, shimManifest)
+
+	appPath := filepath.Join(dataHome(), "applications")
+	if err := os.MkdirAll(appPath, 0755); err != nil {
+		fatal(err)
+	}
+
+	exported := make(map[string]manifest.DesktopEntry)
+	contents := make(map[string][]string)
+	// desktop entries keyed by the MIME types they become the default for
+	defaults := make(map[string][]string)
+	sources, entries := splitDesktopEntries(runScript(rt, args.Container, desktopScript, nil))
+	for _, source := range sources {
+		lines, shimPath := rewriteDesktopEntry(entries[source], resolve)
+		if shimPath == "" {
+			logVerbose("Skipping %s, it does not run an exported executable", source)
+			continue
+		}
+
+		filePath := filepath.Join(appPath, args.Prefix+"-"+filepath.Base(source))
+		entry := manifest.DesktopEntry{
+			Source: source,
+			Shim:   filepath.Base(shimPath),
+		}
+		if desktopMimeDefaults {
+			entry.MimeTypes = desktopMimeTypes(lines)
+			for _, mimeType := range entry.MimeTypes {
+				defaults[mimeType] = append(defaults[mimeType], filepath.Base(filePath))
+			}
+		}
+		contents[filePath] = lines
+		exported[filePath] = entry
+	}
+
+	// before writing anything since icons can be shared between entries
+	replaced := make(map[string]bool)
+	for filePath := range exported {
+		if len(shimManifest.Desktop[filePath].MimeTypes) != 0 {
+			replaced[filepath.Base(filePath)] = true
+		}
+		delete(shimManifest.Desktop, filePath)
+	}
+	removeDesktopEntries(shimManifest, nil)
+
+	var iconNames []string
+	seen := make(map[string]bool)
+	for _, lines := range contents {
+		i := iconLine(lines)
+		if i < 0 || strings.ContainsRune(lines[i], '/') {
+			continue
+		}
+
+		if name := strings.TrimPrefix(lines[i], "Icon="); !seen[name] {
+			seen[name] = true
+			iconNames = append(iconNames, name)
+		}
+	}
+	icons := exportIcons(rt, iconNames)
+
+	for filePath, lines := range contents {
+		if i := iconLine(lines); i >= 0 {
+			name := strings.TrimPrefix(lines[i], "Icon=")
+			if iconPaths, ok := icons[name]; ok {
+				lines[i] = "Icon=" + args.Prefix + "-" + name
+
+				entry := exported[filePath]
+				entry.Icons = iconPaths
+				exported[filePath] = entry
+			}
+		}
+
+		replaceFile(filePath, []byte(strings.Join(lines, "\n")+"\n"))
+	}
+
+	updateMimeDefaults(defaults, replaced)
+
+	removeExportedFiles(shimManifest.DBusServices, nil)
+	if desktopDBusServices {
+		shimManifest.DBusServices = exportDBusServices(rt, resolve)
+	}
+
+	shimManifest.Desktop = exported
+	if err := shimManifest.Write(binPath); err != nil {
+		fatal(err)
+	}
+
+	updateDesktopDatabase(appPath)
+
+	logInfo("Exported %d desktop entries to %s", len(exported), appPath)
+	if desktopDBusServices {
+		logInfo("Exported %d D-Bus services", len(shimManifest.DBusServices))
+	}
+}
+
+// Removes the exported desktop entries of the shims in shims, or all of
+// them if shims is nil, along with their icons and drops them from the
+// manifest
+func removeDesktopEntries(shimManifest *manifest.Manifest, shims map[string]bool) {
+	var icons []string
+	defaults := make(map[string]bool)
+	for filePath, entry := range shimManifest.Desktop {
+		if shims != nil && !shims[entry.Shim] {
+			continue
+		}
+
+		if len(entry.MimeTypes) != 0 {
+			defaults[filepath.Base(filePath)] = true
+		}
+
+		if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fatal(err)
+		}
+		delete(shimManifest.Desktop, filePath)
+		icons = append(icons, entry.Icons...)
+		logVerbose("Removed %s", filePath)
+	}
+	updateMimeDefaults(nil, defaults)
+
+	// shared with an entry that is kept
+	used := make(map[string]bool)
+	for _, entry := range shimManifest.Desktop {
+		for _, icon := range entry.Icons {
+			used[icon] = true
+		}
+	}
+
+	for _, icon := range icons {
+		if used[icon] {
+			continue
+		}
+
+		if err := os.Remove(icon); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fatal(err)
+		}
+	}
+}
+
+// Returns $XDG_DATA_HOME or its default
+func dataHome() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		fatal(err)
+	}
+
+	return filepath.Join(home, ".local", "share")
+}
+
+// Returns a function looking up the path of the shim for a command of
+// the container, either by its path or by its name
+func shimResolver(binPath string, shimManifest *manifest.Manifest) func(string) string {
+	names := make([]string, 0, len(shimManifest.Shims))
+	for fileName := range shimManifest.Shims {
+		names = append(names, fileName)
+	}
+	sort.Strings(names)
+
+	byTarget := make(map[string]string)
+	byName := make(map[string]string)
+	for _, fileName := range names {
+		entry := shimManifest.Shims[fileName]
+		if entry.Container != args.Container {
+			continue
+		}
+
+		byTarget[entry.Target] = fileName
+		if _, ok := byName[filepath.Base(entry.Target)]; !ok {
+			byName[filepath.Base(entry.Target)] = fileName
+		}
+	}
+
+	return func(command string) string {
+		fileName, ok := byTarget[command]
+		if !ok {
+			// eg. /usr/bin/foo when the shim runs /bin/foo
+			if fileName, ok = byName[filepath.Base(command)]; !ok {
+				return ""
+			}
+		}
+
+		return filepath.Join(binPath, fileName)
+	}
+}
+
+// Splits the output of desktopScript into the lines of every entry keyed
+// by its path in the container
+func splitDesktopEntries(lines []string) ([]string, map[string][]string) {
+	var sources []string
+	entries := make(map[string][]string)
+
+	var source string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "\001") {
+			source = line[1:]
+			sources = append(sources, source)
+			continue
+		}
+
+		if source != "" {
+			entries[source] = append(entries[source], line)
+		}
+	}
+
+	return sources, entries
+}
+
+// Rewrites the Exec= and TryExec= lines of a desktop entry to run the
+// shims found by resolve and marks its name with the container. Returns
+// the shim that Exec= runs, or an empty string if there is none.
+func rewriteDesktopEntry(lines []string, resolve func(string) string) ([]string, string) {
+	var rewritten []string
+	var shimPath string
+
+	var group string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			group = trimmed[1 : len(trimmed)-1]
+			rewritten = append(rewritten, line)
+			continue
+		}
+
+		equals := strings.IndexByte(line, '=')
+		if equals < 0 || (group != "Desktop Entry" && !strings.HasPrefix(group, "Desktop Action ")) {
+			rewritten = append(rewritten, line)
+			continue
+		}
+
+		key, value := strings.TrimSpace(line[:equals]), strings.TrimSpace(line[equals+1:])
+		switch {
+		case key == "Exec":
+			command, rest := splitExec(value)
+			path := resolve(command)
+			if path == "" {
+				return nil, ""
+			}
+
+			if group == "Desktop Entry" {
+				shimPath = path
+			}
+			line = "Exec=" + quoteExec(path) + rest
+		case key == "TryExec":
+			// the command does not exist on the host
+			path := resolve(unescapeDesktopString(value))
+			if path == "" {
+				continue
+			}
+			line = "TryExec=" + escapeDesktopString(path)
+		case key == "DBusActivatable":
+			// would start the application on the host instead
+			continue
+		case group == "Desktop Entry" && (key == "Name" || strings.HasPrefix(key, "Name[")):
+			line = fmt.Sprintf("%s=%s (%s)", key, value, args.Container)
+		}
+
+		rewritten = append(rewritten, line)
+	}
+
+	return rewritten, shimPath
+}
+
+// Splits an Exec= value into the command and the rest of the arguments
+func splitExec(value string) (string, string) {
+	value = unescapeDesktopString(value)
+	if !strings.HasPrefix(value, `"`) {
+		if space := strings.IndexByte(value, ' '); space >= 0 {
+			return value[:space], escapeDesktopString(value[space:])
+		}
+		return value, ""
+	}
+
+	var command strings.Builder
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if i+1 < len(value) {
+				i++
+				command.WriteByte(value[i])
+			}
+		case '"':
+			return command.String(), escapeDesktopString(value[i+1:])
+		default:
+			command.WriteByte(value[i])
+		}
+	}
+
+	return command.String(), ""
+}
+
+// Quotes path for an Exec= value if it needs it
+func quoteExec(path string) string {
+	if strings.ContainsAny(path, " \t\"'\\><~|&;$*?#()`") {
+		path = `"` + strings.NewReplacer(`"`, `\"`, "`", "\\`", `$`, `\$`, `\`, `\\`).Replace(path) + `"`
+	}
+
+	return escapeDesktopString(path)
+}
+
+// Desktop entry values escape backslashes and whitespace, and Exec=
+// values are quoted on top of that
+func unescapeDesktopString(value string) string {
+	return strings.NewReplacer(`\\`, `\`, `\s`, " ", `\n`, "\n", `\t`, "\t", `\r`, "\r").Replace(value)
+}
+
+func escapeDesktopString(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\t", `\t`, "\r", `\r`).Replace(value)
+}
+
+func replaceFile(filePath string, data []byte) {
+	tempPath := filePath + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		fatal(err)
+	}
+
+	if err := os.Rename(tempPath, filePath); err != nil {
+		fatal(err)
+	}
+}
+
+// Refreshes the MIME type cache of the applications directory, which
+// desktop environments without a file watcher need
+func updateDesktopDatabase(appPath string) {
+	if _, err := exec.LookPath("update-desktop-database"); err != nil {
+		return
+	}
+
+	logCommand([]string{"update-desktop-database", appPath})
+	if err := exec.CommandContext(runContext, "update-desktop-database", appPath).Run(); err != nil {
+		logWarning("update-desktop-database: %s", err)
+	}
+}
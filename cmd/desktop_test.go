@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitExec(t *testing.T) {
+	tests := []struct {
+		value   string
+		command string
+		rest    string
+	}{
+		{"firefox %u", "firefox", " %u"},
+		{"/usr/bin/gimp", "/usr/bin/gimp", ""},
+		{`"/opt/My App/app" --new-window %F`, "/opt/My App/app", " --new-window %F"},
+		{`"/opt/a\\$b/app"`, `/opt/a$b/app`, ""},
+		{`"/opt/a\\\\b/app"`, `/opt/a\b/app`, ""},
+	}
+
+	for _, test := range tests {
+		command, rest := splitExec(test.value)
+		if command != test.command || rest != test.rest {
+			t.Errorf("splitExec(%q) = %q, %q, want %q, %q", test.value, command, rest, test.command, test.rest)
+		}
+	}
+}
+
+// Exec= values quoted by quoteExec split back into the path
+func TestQuoteExec(t *testing.T) {
+	paths := []string{"/usr/bin/gcc", "/opt/My App/app", `/opt/"quoted"/app`, "/opt/$HOME/app",
+		"/opt/back\\slash", "/opt/`id`"}
+
+	for _, path := range paths {
+		if command, rest := splitExec(quoteExec(path) + " %U"); command != path || rest != " %U" {
+			t.Errorf("%q quoted as %s splits into %q, %q", path, quoteExec(path), command, rest)
+		}
+	}
+
+	if got := quoteExec("/usr/bin/gcc"); got != "/usr/bin/gcc" {
+		t.Errorf("quoteExec quoted a plain path as %s", got)
+	}
+}
+
+func TestEditMimeDefaults(t *testing.T) {
+	lines := []string{
+		"[Added Associations]",
+		"image/png=org.gnome.eog.desktop;",
+		"",
+		"[Default Applications]",
+		"image/png=org.gnome.eog.desktop;",
+		"text/plain=f35-gedit.desktop;org.gnome.TextEditor.desktop;",
+		"",
+	}
+	add := map[string][]string{
+		"image/png":  {"f35-gimp.desktop"},
+		"image/jpeg": {"f35-gimp.desktop"},
+	}
+	remove := map[string]bool{"f35-gedit.desktop": true}
+
+	want := []string{
+		"[Added Associations]",
+		"image/png=org.gnome.eog.desktop;",
+		"",
+		"[Default Applications]",
+		"image/png=f35-gimp.desktop;org.gnome.eog.desktop;",
+		"text/plain=org.gnome.TextEditor.desktop;",
+		"image/jpeg=f35-gimp.desktop;",
+		"",
+	}
+	if got := editMimeDefaults(lines, add, remove); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("edited mimeapps.list is\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// undone by removing the entry again
+	removed := editMimeDefaults(want, nil, map[string]bool{"f35-gimp.desktop": true})
+	if strings.Join(removed, "\n") != strings.Join(append(append([]string{}, want[:4]...),
+		"image/png=org.gnome.eog.desktop;", "text/plain=org.gnome.TextEditor.desktop;", ""), "\n") {
+		t.Errorf("mimeapps.list without the entry is\n%s", strings.Join(removed, "\n"))
+	}
+
+	if got := editMimeDefaults(nil, map[string][]string{"image/png": {"f35-gimp.desktop"}}, nil); strings.Join(got, "\n") !=
+		"[Default Applications]\nimage/png=f35-gimp.desktop;" {
+		t.Errorf("new mimeapps.list is\n%s", strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Diff command. Shows what a refresh would change without changing
+ * anything, or how the executables of two containers differ.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/runtime"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show the executables added, removed, or changed since the shims were generated",
+	Long: `Show the executables added to the container, removed from it, or resolving to
+another path since the shims of the prefix were generated, ie. what refresh would
+change. With --against, show how the executables of another container differ from
+those of --container instead, eg. --container f39 --against f40.`,
+	Args: cobra.NoArgs,
+	Run:  diffCommandFunction,
+}
+
+var diffAgainst string
+
+func init() {
+	addFilterFlags(diffCmd)
+	addForceRefreshFlag(diffCmd)
+	diffCmd.Flags().StringVarP(&diffAgainst, "against", "", "",
+		"container to compare --container with instead of the shims")
+
+	rootCmd.AddCommand(diffCmd)
+}
+
+func diffCommandFunction(_ *cobra.Command, _ []string) {
+	requireArgs("container")
+
+	var before, after map[string]string
+	if diffAgainst == "" {
+		requireArgs("binpath", "prefix")
+		before = shimmedExecutables()
+		after = scannedExecutables()
+	} else {
+		before = scannedExecutables()
+		args.Container = diffAgainst
+		after = scannedExecutables()
+	}
+
+	names := make([]string, 0, len(before)+len(after))
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var added, removed, changed int
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		oldPath, inBefore := before[name]
+		newPath, inAfter := after[name]
+		switch {
+		case !inBefore:
+			fmt.Fprintf(writer, "+ %s\t%s\n", name, newPath)
+			added++
+		case !inAfter:
+			fmt.Fprintf(writer, "- %s\t%s\n", name, oldPath)
+			removed++
+		case oldPath != newPath:
+			fmt.Fprintf(writer, "~ %s\t%s -> %s\n", name, oldPath, newPath)
+			changed++
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		fatal(err)
+	}
+
+	logInfo("%d added, %d removed, %d changed", added, removed, changed)
+}
+
+// Returns the target of every executable the container has keyed by name
+func scannedExecutables() map[string]string {
+	rt := containerRuntime()
+	allExe, err := containerExecutables(rt)
+	if err != nil {
+		fatalScriptError(err)
+	}
+
+	return exeTargets(allExe)
+}
+
+// Returns the target of every shim of the container in the prefix
+// directory keyed by executable name
+func shimmedExecutables() map[string]string {
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	targets := make(map[string]string)
+	if !dirExists(binPath) {
+		return targets
+	}
+	requireManaged(binPath)
+
+	rt, err := runtime.Get(args.Runtime)
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, group := range listDir(args.Prefix, binPath) {
+		if group.Container != args.Container || group.Runtime != rt.Name() {
+			continue
+		}
+
+		for _, listed := range group.Shims {
+			targets[filepath.Base(listed.Target)] = listed.Target
+		}
+	}
+
+	return targets
+}
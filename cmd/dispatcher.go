@@ -0,0 +1,175 @@
+/*
+ * Shim modes where every shim is a symlink to a single file. Either the
+ * binary dispatcher (see pkg/dispatch) or a launcher script that picks
+ * the command from the name it was run as.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/dispatch"
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"btb/pkg/shim"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Writes the dispatcher or launcher into binPath and links targets to it
+func writeLinkedShims(rt runtime.Runtime, binPath string, previous *manifest.Manifest,
+	targets map[string]string, skipped map[string]bool) {
+	linkTarget, unused := dispatch.BinaryName, shim.LauncherName
+	if args.ShimMode == "symlink" {
+		linkTarget, unused = unused, linkTarget
+		writeLauncher(rt, binPath, targets)
+	} else {
+		installDispatcher(binPath)
+	}
+
+	// left over from a different shim mode
+	if err := os.Remove(filepath.Join(binPath, unused)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fatal(err)
+	}
+
+	links := make(map[string]string, len(targets))
+	dispatchManifest := make(dispatch.Manifest, len(targets))
+	for fileName, exePath := range targets {
+		if skipped[fileName] {
+			continue
+		}
+
+		links[fileName] = linkTarget
+		entry := dispatch.Entry{
+			Container: args.Container,
+			Runtime:   rt.Name(),
+			Target:    exePath,
+			Command:   shimRenderer().Command(rt, args.Container, exePath),
+		}
+		if starter, ok := rt.(runtime.Starter); ok && args.StartTimeout > 0 {
+			entry.Running = starter.RunningCommand(args.Container)
+			entry.Start = starter.StartCommand(args.Container)
+			entry.StartTimeout = int((args.StartTimeout + time.Second - 1) / time.Second)
+		}
+		if _, ok := rt.(runtime.HostMounter); ok && args.OnUnsharedCwd != "ignore" {
+			entry.OnUnsharedCwd = args.OnUnsharedCwd
+		}
+		if checker, ok := rt.(runtime.Checker); ok && args.HostFallback {
+			entry.Exists = checker.ExistsCommand(args.Container)
+			entry.Fallback = filepath.Base(exePath)
+		}
+		dispatchManifest[fileName] = entry
+
+		linkPath := filepath.Join(binPath, fileName)
+		if link, err := os.Readlink(linkPath); err == nil && link == linkTarget {
+			continue
+		}
+
+		if err := os.Remove(linkPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fatal(err)
+		}
+		if err := os.Symlink(linkTarget, linkPath); err != nil {
+			fatal(err)
+		}
+	}
+
+	if err := dispatch.WriteManifest(binPath, dispatchManifest); err != nil {
+		fatal(err)
+	}
+
+	writeManifest(rt, binPath, previous, targets, links, skipped)
+}
+
+func writeLauncher(rt runtime.Runtime, binPath string, targets map[string]string) {
+	contents, err := shimRenderer().RenderLauncher(rt, args.Container, targets)
+	if err != nil {
+		fatal(err)
+	}
+
+	launcherPath := filepath.Join(binPath, shim.LauncherName)
+	if err := os.WriteFile(launcherPath+".tmp", []byte(contents), 0755); err != nil {
+		fatal(err)
+	}
+
+	if err := os.Rename(launcherPath+".tmp", launcherPath); err != nil {
+		fatal(err)
+	}
+}
+
+func installDispatcher(binPath string) {
+	source, err := os.Open(currentExePath())
+	if err != nil {
+		fatal(err)
+	}
+	defer source.Close()
+
+	// write then rename so that running dispatchers are not disturbed
+	dispatcherPath := filepath.Join(binPath, dispatch.BinaryName)
+	tempPath := dispatcherPath + ".tmp"
+
+	dest, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		fatal(err)
+	}
+
+	if _, err := io.Copy(dest, source); err != nil {
+		fatal(err)
+	}
+
+	if err := dest.Close(); err != nil {
+		fatal(err)
+	}
+
+	if err := os.Rename(tempPath, dispatcherPath); err != nil {
+		fatal(err)
+	}
+}
+
+func refreshLinkedShims(rt runtime.Runtime, binPath string, previous *manifest.Manifest,
+	targets map[string]string, skipped map[string]bool) (int, int, int) {
+	dispatchManifest, err := dispatch.ReadManifest(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	entries, err := os.ReadDir(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	var added, updated, removed int
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || skipped[entry.Name()] {
+			continue
+		}
+
+		if _, ok := targets[entry.Name()]; !ok {
+			if err := os.Remove(filepath.Join(binPath, entry.Name())); err != nil {
+				fatal(err)
+			}
+			removed++
+		}
+	}
+
+	for fileName, exePath := range targets {
+		if skipped[fileName] {
+			continue
+		}
+
+		if entry, ok := dispatchManifest[fileName]; !ok {
+			added++
+		} else if entry.Target != exePath || entry.Container != args.Container || entry.Runtime != rt.Name() {
+			updated++
+		}
+	}
+
+	writeLinkedShims(rt, binPath, previous, targets, skipped)
+
+	return added, updated, removed
+}
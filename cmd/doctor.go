@@ -0,0 +1,223 @@
+/*
+ * Doctor command. Checks the environment btb and its shims run in and
+ * suggests fixes for what is wrong.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/btb"
+	"btb/pkg/runtime"
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the runtime, container, and bin directory for problems",
+	Args:  cobra.NoArgs,
+	Run:   doctorCommandFunction,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorFailures int
+
+// Prints the result of a check with how to fix it if it failed
+func report(ok bool, what string, fix string) {
+	if ok {
+		fmt.Printf("ok    %s\n", what)
+		return
+	}
+
+	fmt.Printf("FAIL  %s\n", what)
+	if fix != "" {
+		fmt.Printf("      fix: %s\n", fix)
+	}
+	doctorFailures++
+}
+
+func doctorCommandFunction(_ *cobra.Command, _ []string) {
+	rt, err := runtime.Get(args.Runtime)
+	report(err == nil, fmt.Sprintf("runtime %s is known", args.Runtime),
+		fmt.Sprintf("use --runtime with one of %s", strings.Join(runtime.Names(), ", ")))
+
+	if rt != nil {
+		checkRuntime(rt)
+	}
+
+	_, err = exec.LookPath("bash")
+	report(err == nil, "bash is installed, which script shims run with",
+		"install bash or use --shim-mode dispatcher")
+
+	checkBinPath()
+
+	if doctorFailures != 0 {
+		fmt.Printf("\n%d checks failed\n", doctorFailures)
+		os.Exit(1)
+	}
+}
+
+// Arguments that print the version of programs without --version
+var programVersionArgs = map[string][]string{
+	"kubectl": {"version", "--client"},
+	"ssh":     {"-V"},
+}
+
+// Checks the programs of the runtime and, with --container, the container
+func checkRuntime(rt runtime.Runtime) {
+	candidates := []string{rt.Command("", "sh")[0]}
+	checker, isChecker := rt.(runtime.Checker)
+	if isChecker {
+		// eg. podman for toolbox
+		candidates = append(candidates, checker.ExistsCommand("")[0])
+	}
+
+	// sh only runs the scripts of some runtimes, eg. ssh
+	var programs []string
+	for _, program := range candidates {
+		if program != "sh" && (len(programs) == 0 || program != programs[0]) {
+			programs = append(programs, program)
+		}
+	}
+
+	found := true
+	for _, program := range programs {
+		path, err := exec.LookPath(program)
+		if err != nil {
+			report(false, fmt.Sprintf("%s is installed", program),
+				fmt.Sprintf("install %s or pick another --runtime", program))
+			found = false
+			continue
+		}
+
+		versionArgs, ok := programVersionArgs[program]
+		if !ok {
+			versionArgs = []string{"--version"}
+		}
+
+		output, err := doctorCommand(path, versionArgs...)
+		version := strings.SplitN(strings.TrimSpace(output), "\n", 2)[0]
+		report(err == nil, fmt.Sprintf("%s is installed: %s", program, version),
+			fmt.Sprintf("check that %s works", strings.Join(append([]string{program}, versionArgs...), " ")))
+	}
+
+	if args.Container == "" {
+		fmt.Println("skip  container checks, no --container given")
+		return
+	}
+
+	if !found {
+		return
+	}
+
+	if isChecker {
+		command := checker.ExistsCommand(args.Container)
+		_, err := doctorCommand(command[0], command[1:]...)
+		report(err == nil, fmt.Sprintf("container %s exists", args.Container),
+			"create the container or fix --container")
+		if err != nil {
+			return
+		}
+	}
+
+	if starter, ok := rt.(runtime.Starter); ok {
+		command := starter.RunningCommand(args.Container)
+		output, err := doctorCommand(command[0], command[1:]...)
+		running := err == nil && strings.TrimSpace(output) == "true"
+		report(running, fmt.Sprintf("container %s is running", args.Container),
+			fmt.Sprintf("%s, or let shims start it with --start-timeout",
+				strings.Join(starter.StartCommand(args.Container), " ")))
+	}
+
+	command := rt.Command(args.Container, btb.ScriptCommand(args.Shell, "exit 0")...)
+	_, err := doctorCommand(command[0], command[1:]...)
+	report(err == nil, fmt.Sprintf("%s runs in container %s", args.Shell, args.Container),
+		fmt.Sprintf("check the output of %s", strings.Join(command, " ")))
+}
+
+// Checks that the bin directory can hold the shims and is set up
+func checkBinPath() {
+	if args.BinPath == "" {
+		fmt.Println("skip  bin directory checks, no --binpath given")
+		return
+	}
+
+	info, err := os.Stat(args.BinPath)
+	report(err == nil && info.IsDir(), fmt.Sprintf("%s is a directory", args.BinPath),
+		fmt.Sprintf("mkdir -p %s", args.BinPath))
+	if err != nil {
+		return
+	}
+
+	file, err := os.CreateTemp(args.BinPath, ".btbDoctor")
+	if err == nil {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	report(err == nil, fmt.Sprintf("%s is writable", args.BinPath),
+		fmt.Sprintf("check the owner and permissions of %s", args.BinPath))
+
+	if args.Prefix != "" {
+		binPath := filepath.Join(args.BinPath, args.Prefix)
+		report(onPath(binPath), fmt.Sprintf("%s is on PATH", binPath),
+			"btb path-setup --write")
+	}
+
+	entries, err := os.ReadDir(args.BinPath)
+	if err != nil {
+		report(false, fmt.Sprintf("%s can be read", args.BinPath), err.Error())
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		dir := filepath.Join(args.BinPath, name)
+
+		if strings.HasSuffix(name, ".btbNew") || strings.HasSuffix(name, ".btbOld") {
+			report(false, fmt.Sprintf("%s is left over from an interrupted run", dir),
+				"run sync or refresh for its prefix, which cleans it up")
+			continue
+		}
+
+		if isManagedDir(dir) && len(listDir(name, dir)) == 0 {
+			report(false, fmt.Sprintf("%s has a .btbMarker but no shims", dir),
+				fmt.Sprintf("btb clean --prefix %s", name))
+		}
+	}
+}
+
+// Reports if dir is one of the directories on PATH
+func onPath(dir string) bool {
+	for _, pathDir := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.Clean(pathDir) == filepath.Clean(dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Runs a command for a check and returns its output
+func doctorCommand(name string, commandArgs ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(runContext, args.Timeout)
+	defer cancel()
+
+	logCommand(append([]string{name}, commandArgs...))
+	output, err := exec.CommandContext(ctx, name, commandArgs...).CombinedOutput()
+	return string(output), err
+}
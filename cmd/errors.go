@@ -0,0 +1,94 @@
+/*
+ * Exit codes. Errors end up in fatal, which picks the exit code from the
+ * class of the error so scripts running btb can tell them apart:
+ *   1    anything else
+ *   3    the container does not exist
+ *   4    permission denied
+ *   5    the user said no or cancelled
+ *   130  interrupted
+ * A command failing in the container passes on its own exit code.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+)
+
+const (
+	exitFailure     = 1
+	exitNoContainer = 3
+	exitPermission  = 4
+	exitAborted     = 5
+	exitInterrupted = 130
+)
+
+var (
+	errNoContainer = errors.New("container does not exist")
+	errAborted     = errors.New("aborted")
+)
+
+// Error belonging to one of the classes above while keeping its message
+type classError struct {
+	err   error
+	class error
+}
+
+func (err *classError) Error() string {
+	return err.err.Error()
+}
+
+func (err *classError) Unwrap() error {
+	return err.err
+}
+
+func (err *classError) Is(target error) bool {
+	return target == err.class
+}
+
+// Returns the exit code for the class of err
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0:
+		return exitErr.ExitCode()
+	case errors.Is(err, errNoContainer):
+		return exitNoContainer
+	case errors.Is(err, fs.ErrPermission):
+		return exitPermission
+	case errors.Is(err, errAborted):
+		return exitAborted
+	}
+
+	return exitFailure
+}
+
+// Prints err, runs the cleanups registered with onInterrupt, and exits
+// with the exit code of its class. A command that failed is not printed
+// as it already printed why, unless err says more.
+func fatal(err error) {
+	clearProgress()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() <= 0 {
+		log.Print(err)
+	}
+
+	runCleanups()
+	os.Exit(exitCode(err))
+}
+
+// Exits after the user said no or cancelled
+func abort(message string) {
+	fatal(&classError{errors.New(message), errAborted})
+}
+
+// ExitWithError prints err and exits with the exit code of its class
+func ExitWithError(err error) {
+	fatal(err)
+}
@@ -0,0 +1,109 @@
+/*
+ * Export command. Creates a single shim without regenerating the
+ * rest of the prefix directory.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export EXE",
+	Short: "Create a shim for a single executable in a container",
+	Long: `Create a shim for a single executable in a container.
+EXE is either a name looked up on the container's PATH or an absolute path.`,
+	Args: cobra.ExactArgs(1),
+	Run:  exportCommandFunction,
+}
+
+var exportAs string
+
+func init() {
+	addShimFlags(exportCmd)
+	addNameFormatFlag(exportCmd)
+	exportCmd.Flags().StringVarP(&exportAs, "as", "", "", "name of the shim (default from --name-format)")
+
+	rootCmd.AddCommand(exportCmd)
+}
+
+func exportCommandFunction(_ *cobra.Command, cmdArgs []string) {
+	requireArgs("binpath", "prefix", "container")
+	checkNameFormat()
+
+	rt := containerRuntime()
+
+	exePath := cmdArgs[0]
+	if filepath.IsAbs(exePath) {
+		if missingTargets(rt, args.Container, []string{exePath})[exePath] {
+			fatal(fmt.Errorf("%s is not an executable in %s", exePath, args.Container))
+		}
+	} else {
+		resolved := runScript(rt, args.Container, resolveScript, nil, exePath)
+		if len(resolved) == 0 || !strings.HasPrefix(resolved[0], "/") {
+			fatal(fmt.Errorf("%s was not found on the PATH of %s", exePath, args.Container))
+		}
+		exePath = resolved[0]
+	}
+
+	fileName := exportAs
+	if fileName == "" {
+		fileName = shimName(filepath.Base(exePath))
+	}
+	if !validName(fileName) {
+		fatal(fmt.Errorf("%q is not a valid shim name, it must be a file name not starting with a dot", fileName))
+	}
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	defer lockPrefixDir(binPath)()
+	var mode os.FileMode
+	created := func() {}
+	if dirExists(binPath) {
+		requireManaged(binPath)
+
+		parentStat, err := os.Stat(args.BinPath)
+		if err != nil {
+			fatal(err)
+		}
+		mode = parentStat.Mode()
+	} else {
+		mode = createPrefixDir(binPath, rt)
+		// a prefix directory with only the marker in it is no use
+		created = onInterrupt(func() { removePartial(binPath) })
+	}
+
+	filePath := filepath.Join(binPath, fileName)
+	if _, err := os.Stat(filePath); err == nil {
+		if !confirm(fmt.Sprintf("overwrite: %s", filePath)) {
+			abort("Not overwriting existing shim")
+		}
+	}
+
+	contents := renderShim(rt, args.Container, exePath)
+	writeShim(filePath, contents, mode)
+
+	shimManifest := readManifest(binPath)
+	shimManifest.Shims[fileName] = manifest.Shim{
+		Container: args.Container,
+		Runtime:   rt.Name(),
+		Target:    exePath,
+		Hash:      manifest.Hash([]byte(contents)),
+		Generated: time.Now(),
+	}
+	if err := shimManifest.Write(binPath); err != nil {
+		fatal(err)
+	}
+	created()
+
+	logInfo("Exported %s as %s", exePath, filePath)
+}
@@ -0,0 +1,165 @@
+/*
+ * Gc command. Removes the prefix directories whose containers no longer
+ * exist, eg. toolboxes deleted since, going by their manifests and what
+ * the runtimes list.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/btb"
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove the prefix directories of containers that no longer exist",
+	Long: `Remove the prefix directories in the bin directory whose containers no
+longer exist, asking for every one of them. Prefix directories are only
+removed when the runtime lists its containers and every container of their
+shims is missing from the list, never when it cannot be asked. With --dry-run
+they are only listed.`,
+	Args: cobra.NoArgs,
+	Run:  gcCommandFunction,
+}
+
+var gcDryRun bool
+
+func init() {
+	gcCmd.Flags().BoolVarP(&gcDryRun, "dry-run", "", false, "only list the prefix directories to remove")
+
+	rootCmd.AddCommand(gcCmd)
+}
+
+func gcCommandFunction(_ *cobra.Command, _ []string) {
+	requireArgs("binpath")
+
+	entries, err := os.ReadDir(args.BinPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	// whether every container of a runtime is gone, asked once
+	gone := make(map[string]bool)
+	removed := 0
+	for _, entry := range entries {
+		binPath := filepath.Join(args.BinPath, entry.Name())
+		if !entry.IsDir() || !isManagedDir(binPath) {
+			continue
+		}
+
+		containers := prefixContainers(binPath)
+		if len(containers) == 0 {
+			continue
+		}
+
+		orphaned := true
+		for _, key := range containers {
+			if _, ok := gone[key]; !ok {
+				fields := strings.SplitN(key, "/", 2)
+				gone[key] = containerGone(fields[0], fields[1])
+			}
+			orphaned = orphaned && gone[key]
+		}
+		if !orphaned {
+			continue
+		}
+
+		what := fmt.Sprintf("%s, container %s no longer exists", binPath, strings.Join(containers, ", "))
+		if gcDryRun {
+			fmt.Println(what)
+			continue
+		}
+
+		logInfo("%s", what)
+		if !confirm(fmt.Sprintf("remove %s", binPath)) {
+			continue
+		}
+
+		func() {
+			defer lockPrefixDir(binPath)()
+			removePrefixDir(binPath)
+		}()
+		removed++
+	}
+
+	if !gcDryRun {
+		logInfo("Removed %d prefix directories", removed)
+	}
+}
+
+// Returns the containers the shims of binPath run in as RUNTIME/CONTAINER,
+// from its manifest or, without one, its marker
+func prefixContainers(binPath string) []string {
+	seen := make(map[string]bool)
+	shimManifest, err := manifest.Read(binPath)
+	if err == nil {
+		for _, entry := range shimManifest.Shims {
+			seen[entry.Runtime+"/"+entry.Container] = true
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logWarning("%s", err)
+		return nil
+	}
+
+	if len(seen) == 0 {
+		marker, err := btb.ReadMarker(binPath)
+		if err != nil || marker.Container == "" {
+			return nil
+		}
+		seen[marker.Runtime+"/"+marker.Container] = true
+	}
+
+	containers := make([]string, 0, len(seen))
+	for key := range seen {
+		containers = append(containers, key)
+	}
+	sort.Strings(containers)
+
+	return containers
+}
+
+// Reports if the runtime named runtimeName says that container does not
+// exist, false when it cannot tell, eg. as it is not installed
+func containerGone(runtimeName string, container string) bool {
+	rt, err := runtime.Get(runtimeName)
+	if err != nil {
+		return false
+	}
+
+	if checker, ok := rt.(runtime.Checker); ok {
+		command := checker.ExistsCommand(container)
+		logCommand(command)
+		if exec.CommandContext(runContext, command[0], command[1:]...).Run() == nil {
+			return false
+		}
+	}
+
+	containers, ok, err := listContainers(rt)
+	if err != nil {
+		logWarning("%s, keeping the prefix directories of its containers", err)
+		return false
+	} else if !ok {
+		return false
+	}
+
+	for _, name := range containers {
+		if name == container {
+			return false
+		}
+	}
+
+	return true
+}
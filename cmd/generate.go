@@ -0,0 +1,124 @@
+/*
+ * Generate command. Generates the shims for a list of executables, eg.
+ * what btb scan --output json printed after a review, instead of
+ * scanning the container.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var generateCmd = &cobra.Command{
+	Use:   "generate --from-list FILE",
+	Short: "Generate shims for a list of executables without scanning the container",
+	Long: `Generate shims for the executables of a container listed in a file, or on
+stdin with --from-list -, instead of scanning the container. The list has an
+absolute path per line, leaving out empty lines and those starting with #, or
+is a JSON array of paths or of the executables btb scan --output json prints.
+Like in PATH, the first executable of every name is exported. The name filters
+still apply, but nothing else about the executables is checked.`,
+	Args: cobra.NoArgs,
+	Run:  generateCommandFunction,
+}
+
+var generateFromList string
+
+// Executables sync exports instead of those it finds, set by generate
+var listedExecutables []string
+
+func init() {
+	addNameFilterFlags(generateCmd)
+	addShimFlags(generateCmd)
+	addShimModeFlag(generateCmd)
+	addOnModifiedFlag(generateCmd)
+	addJobsFlag(generateCmd)
+	addAliasLinksFlag(generateCmd)
+	addOnConflictFlag(generateCmd)
+	addNameFormatFlag(generateCmd)
+	addOutputFlag(generateCmd)
+	generateCmd.Flags().StringVarP(&generateFromList, "from-list", "", "",
+		"file listing the executables to export, - for stdin")
+
+	rootCmd.AddCommand(generateCmd)
+}
+
+func generateCommandFunction(_ *cobra.Command, _ []string) {
+	checkOutputFormat()
+
+	var data []byte
+	var err error
+	switch generateFromList {
+	case "":
+		fatal(errors.New("--from-list is required"))
+	case "-":
+		// the answers to prompts would be read from the list
+		if !args.Yes {
+			fatal(errors.New("--from-list - needs --yes"))
+		}
+		data, err = io.ReadAll(stdin)
+	default:
+		data, err = os.ReadFile(generateFromList)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	if listedExecutables, err = parseExecutableList(data); err != nil {
+		fatal(fmt.Errorf("%s: %w", generateFromList, err))
+	}
+	logVerbose("Read %d executables from %s", len(listedExecutables), generateFromList)
+
+	if err := syncProfile(args.Profile); err != nil {
+		fatalScriptError(err)
+	}
+	printSummaries([]*runSummary{summary}, false)
+}
+
+// Returns the paths of a list of executables, one per line or a JSON
+// array of paths or of the objects of btb scan
+func parseExecutableList(data []byte) ([]string, error) {
+	allExe := []string{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) != 0 && trimmed[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			var exe scannedExe
+			if err := json.Unmarshal(item, &exe.Path); err != nil {
+				if err := json.Unmarshal(item, &exe); err != nil {
+					return nil, err
+				}
+			}
+			allExe = append(allExe, exe.Path)
+		}
+	} else {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				allExe = append(allExe, line)
+			}
+		}
+	}
+
+	for _, exePath := range allExe {
+		if !filepath.IsAbs(exePath) {
+			return nil, fmt.Errorf("%q is not an absolute path", exePath)
+		}
+	}
+
+	return allExe, nil
+}
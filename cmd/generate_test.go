@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExecutableList(t *testing.T) {
+	want := []string{"/usr/bin/rg", "/home/user/.cargo/bin/fd"}
+	lists := []string{
+		"/usr/bin/rg\n\n# from cargo\n/home/user/.cargo/bin/fd\n",
+		`["/usr/bin/rg", "/home/user/.cargo/bin/fd"]`,
+		`[{"name": "rg", "path": "/usr/bin/rg", "shadowed": false}, {"path": "/home/user/.cargo/bin/fd"}]`,
+	}
+
+	for _, list := range lists {
+		got, err := parseExecutableList([]byte(list))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s parses as %q (%v), want %q", list, got, err, want)
+		}
+	}
+
+	for _, list := range []string{"rg\n", `["rg"]`, `[1]`} {
+		if _, err := parseExecutableList([]byte(list)); err == nil {
+			t.Errorf("%s parses without an error", list)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Hooks from the config file run before scanning and after generating
+ * the shims. Every hook is a line of sh getting the run described in
+ * variables:
+ *   BTB_HOOK       pre_scan or post_generate
+ *   BTB_PROFILE    profile synced, empty for the defaults
+ *   BTB_CONTAINER  container, BTB_RUNTIME its runtime
+ *   BTB_PREFIX     prefix, BTB_PATH the prefix directory
+ *   BTB_CREATED, BTB_UPDATED, BTB_REMOVED, BTB_SKIPPED
+ *                  shim counts, only for post_generate
+ * A failing pre_scan hook stops the sync, a failing post_generate hook
+ * is a warning.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/config"
+	"btb/pkg/runtime"
+	"btb/pkg/shim"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// Returns the variables describing the run for the hooks of name
+func hookEnv(rt runtime.Runtime, name string) []string {
+	env := []string{
+		"BTB_HOOK=" + name,
+		"BTB_PROFILE=" + summary.Profile,
+		"BTB_CONTAINER=" + args.Container,
+		"BTB_RUNTIME=" + rt.Name(),
+		"BTB_PREFIX=" + args.Prefix,
+		"BTB_PATH=" + filepath.Join(args.BinPath, args.Prefix),
+	}
+
+	if name == "post_generate" {
+		env = append(env,
+			"BTB_CREATED="+strconv.Itoa(summary.Created),
+			"BTB_UPDATED="+strconv.Itoa(summary.Updated),
+			"BTB_REMOVED="+strconv.Itoa(summary.Removed),
+			"BTB_SKIPPED="+strconv.Itoa(summary.Skipped))
+	}
+
+	return env
+}
+
+// Runs the hooks of name in order and returns the first error
+func runHooks(rt runtime.Runtime, name string, hooks []config.Hook) error {
+	env := hookEnv(rt, name)
+	for _, hook := range hooks {
+		command := []string{"sh", "-c", hook.Run}
+		if hook.InContainer && !args.InContainer {
+			command = shim.Command(rt, args.Container, env, command...)
+		}
+
+		logVerbose("Running %s hook %s", name, hook.Run)
+		logCommand(command)
+
+		cmd := exec.CommandContext(runContext, command[0], command[1:]...)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout = logWriter
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			if runContext.Err() != nil {
+				interrupted()
+			}
+			return fmt.Errorf("%s hook %q: %w", name, hook.Run, err)
+		}
+	}
+
+	return nil
+}
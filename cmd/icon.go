@@ -0,0 +1,81 @@
+/*
+ * Icons of exported desktop entries. Copied out of the container's
+ * hicolor theme into the user's so launchers do not fall back to a
+ * generic icon, and renamed after the prefix to not replace host icons.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/runtime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Writes a tar archive of the hicolor icons named by the arguments to
+// stdout, eg. 48x48/apps/NAME.png, or nothing if there are none
+const iconScript = `cd /usr/share/icons/hicolor 2>/dev/null || exit 0
+for name; do
+	for ext in png svg xpm; do
+		for file in */apps/"$name.$ext"; do
+			[ -f "$file" ] && set -- "$@" "$file"
+		done
+	done
+	shift
+done
+[ $# -eq 0 ] && exit 0
+exec tar -chf - -- "$@"
+`
+
+// Copies the named icons from the container and returns the paths they
+// were written to keyed by name
+func exportIcons(rt runtime.Runtime, names []string) map[string][]string {
+	exported := make(map[string][]string)
+	if len(names) == 0 {
+		return exported
+	}
+
+	iconPath := filepath.Join(dataHome(), "icons", "hicolor")
+	output := runScriptOutput(rt, args.Container, iconScript, nil, names...)
+	forEachFile(output, func(name string, data []byte) {
+		parts := strings.Split(name, "/")
+		if len(parts) != 3 || parts[1] != "apps" || parts[0] == "" || strings.HasPrefix(parts[0], ".") {
+			return
+		}
+
+		dirPath := filepath.Join(iconPath, parts[0], "apps")
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			fatal(err)
+		}
+
+		filePath := filepath.Join(dirPath, args.Prefix+"-"+parts[2])
+		replaceFile(filePath, data)
+
+		iconName := strings.TrimSuffix(parts[2], filepath.Ext(parts[2]))
+		exported[iconName] = append(exported[iconName], filePath)
+	})
+
+	return exported
+}
+
+// Returns the index of the Icon= line of a desktop entry or -1
+func iconLine(lines []string) int {
+	var group string
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			group = trimmed[1 : len(trimmed)-1]
+			continue
+		}
+
+		if group == "Desktop Entry" && strings.HasPrefix(line, "Icon=") {
+			return i
+		}
+	}
+
+	return -1
+}
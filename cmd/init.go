@@ -0,0 +1,181 @@
+/*
+ * Init command. Walks a new user through picking a container, prefix,
+ * and bin directory and writes them to the config file.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/config"
+	"btb/pkg/runtime"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Set up the config file for a container interactively",
+	Args:  cobra.NoArgs,
+	Run:   initCommandFunction,
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+}
+
+func initCommandFunction(_ *cobra.Command, _ []string) {
+	rt, err := runtime.Get(args.Runtime)
+	if err != nil {
+		fatal(err)
+	}
+
+	container := pickContainer(rt)
+	if container == "" {
+		abort("no container picked")
+	}
+
+	prefix := args.Prefix
+	if prefix == "" {
+		prefix = suggestPrefix(container)
+	}
+	prefix = prompt("Prefix of the shims", prefix)
+	if !validName(prefix) {
+		fatal(fmt.Errorf("%q is not a valid prefix", prefix))
+	}
+
+	binPath := prompt("Directory to put the prefix directory in", args.BinPath)
+	if !filepath.IsAbs(binPath) {
+		fatal(fmt.Errorf("%s is not an absolute path", binPath))
+	}
+
+	configPath := args.ConfigPath
+	if configPath == "" {
+		if configPath, err = config.DefaultPath(); err != nil {
+			fatal(err)
+		}
+	}
+
+	profile := config.Profile{
+		BinPath:   binPath,
+		Prefix:    prefix,
+		Container: container,
+		Runtime:   rt.Name(),
+	}
+
+	// keep what is already configured and add the container as a profile
+	if _, err := os.Stat(configPath); err == nil {
+		if _, ok := conf.Profiles[prefix]; ok && !confirm(fmt.Sprintf("replace profile %s", prefix)) {
+			abort("Not replacing the existing profile")
+		}
+
+		if conf.Profiles == nil {
+			conf.Profiles = make(map[string]config.Profile)
+		}
+		conf.Profiles[prefix] = profile
+		fmt.Printf("Adding profile %s, use it with --profile %s\n", prefix, prefix)
+	} else {
+		conf = &config.Config{Profile: profile}
+	}
+
+	if err := conf.Write(configPath); err != nil {
+		fatal(err)
+	}
+	fmt.Printf("Wrote %s\n", configPath)
+
+	if err := os.MkdirAll(binPath, 0755); err != nil {
+		fatal(err)
+	}
+
+	addToPath(filepath.Join(binPath, prefix), pathFirst(), "init")
+
+	fmt.Println("Run btb sync to generate the shims")
+}
+
+// Lets the user pick one of the containers of rt or enter a name if it
+// cannot list them
+func pickContainer(rt runtime.Runtime) string {
+	containers, ok, err := listContainers(rt)
+	if err != nil {
+		fatal(err)
+	}
+
+	if !ok || len(containers) == 0 {
+		if ok {
+			fmt.Printf("No %s containers found\n", rt.Name())
+		}
+		return prompt("Container", args.Container)
+	}
+
+	defaultChoice := "1"
+	for i, container := range containers {
+		fmt.Printf("%3d  %s\n", i+1, container)
+		if container == args.Container {
+			defaultChoice = strconv.Itoa(i + 1)
+		}
+	}
+
+	choice := prompt("Container (number or name)", defaultChoice)
+	if index, err := strconv.Atoi(choice); err == nil {
+		if index < 1 || index > len(containers) {
+			fatal(fmt.Errorf("%d is not one of the listed containers", index))
+		}
+		return containers[index-1]
+	}
+
+	if err := checkContainer(rt, choice); err != nil {
+		fatal(err)
+	}
+
+	return choice
+}
+
+// Suggests a short prefix for a container made of its first letter and
+// version, eg. f39 for fedora-toolbox-39, or its name if there is none
+func suggestPrefix(container string) string {
+	name := strings.ToLower(container)
+	// images for docker-run, eg. registry.fedoraproject.org/fedora:39
+	name = name[strings.LastIndex(name, "/")+1:]
+	end := len(name)
+	for end > 0 && strings.ContainsRune("0123456789.", rune(name[end-1])) {
+		end--
+	}
+
+	version := strings.ReplaceAll(name[end:], ".", "")
+	if version == "" || name[0] < 'a' || name[0] > 'z' {
+		return strings.ReplaceAll(name, ":", "-")
+	}
+
+	return name[:1] + version
+}
+
+// Asks the user for a value. Takes defaultValue for an empty answer or
+// with --yes.
+func prompt(question string, defaultValue string) string {
+	if args.Yes {
+		return defaultValue
+	}
+
+	if defaultValue != "" {
+		fmt.Printf("%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+
+	response, err := stdin.ReadString('\n')
+	if err != nil {
+		fatal(err)
+	}
+
+	if response = strings.TrimSpace(response); response == "" {
+		return defaultValue
+	}
+
+	return response
+}
@@ -0,0 +1,112 @@
+/*
+ * Interrupt handling. SIGINT and SIGTERM cancel runContext, which kills
+ * the commands running in the container, then the cleanups registered
+ * with onInterrupt run, eg. to remove a staged prefix directory, and btb
+ * exits. fatal runs the cleanups too.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Context of the whole run, every command btb runs is started with it
+var runContext = context.Background()
+
+var cleanups = struct {
+	sync.Mutex
+	funcs map[int]func()
+	next  int
+}{funcs: make(map[int]func())}
+
+// Cancels runContext on SIGINT or SIGTERM and exits once the cleanups ran.
+// The returned function stops that without exiting.
+func handleSignals() func() {
+	var cancel context.CancelFunc
+	runContext, cancel = context.WithCancel(context.Background())
+
+	// the signals themselves are watched since runContext is also done
+	// once stopped
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-signals; ok {
+			cancel()
+			interrupted()
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel()
+	}
+}
+
+// Runs fn if btb is interrupted until the returned function is called
+func onInterrupt(fn func()) func() {
+	cleanups.Lock()
+	defer cleanups.Unlock()
+
+	id := cleanups.next
+	cleanups.next++
+	cleanups.funcs[id] = fn
+
+	return func() {
+		cleanups.Lock()
+		defer cleanups.Unlock()
+		delete(cleanups.funcs, id)
+	}
+}
+
+// Runs fn without being interrupted partway, eg. to swap directories
+func uninterrupted(fn func()) {
+	cleanups.Lock()
+	defer cleanups.Unlock()
+	fn()
+}
+
+// Runs the cleanups and exits. Never returns, so callers noticing the
+// interrupt themselves wait here for the exit.
+func interrupted() {
+	runCleanups()
+	log.Print("interrupted")
+	os.Exit(exitInterrupted)
+}
+
+// Runs the cleanups before exiting. Callers must exit afterwards, no
+// cleanups or uninterrupted sections run anymore.
+func runCleanups() {
+	// held until the exit
+	cleanups.Lock()
+
+	for _, fn := range cleanups.funcs {
+		fn()
+	}
+}
+
+// Removes a partially written directory, retrying since files may still
+// be written into it
+func removePartial(dirPath string) {
+	var err error
+	for i := 0; i < 3; i++ {
+		if err = os.RemoveAll(dirPath); err == nil {
+			err = manifest.Remove(dirPath)
+		}
+		if err == nil {
+			return
+		}
+	}
+
+	logWarning("could not remove %s: %s", dirPath, err)
+}
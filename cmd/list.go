@@ -0,0 +1,206 @@
+/*
+ * List command. Shows the shims btb manages.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/dispatch"
+	"btb/pkg/manifest"
+	"btb/pkg/shim"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type listedShim struct {
+	Name      string    `json:"name"`
+	Target    string    `json:"target"`
+	Generated time.Time `json:"generated"`
+}
+
+type listedGroup struct {
+	Prefix    string       `json:"prefix"`
+	Path      string       `json:"path"`
+	Container string       `json:"container"`
+	Runtime   string       `json:"runtime"`
+	Shims     []listedShim `json:"shims"`
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the shims managed by btb",
+	Args:  cobra.NoArgs,
+	Run:   listCommandFunction,
+}
+
+var listFormat string
+
+func init() {
+	listCmd.Flags().StringVarP(&listFormat, "format", "", "table", "output format (table, json)")
+
+	rootCmd.AddCommand(listCmd)
+}
+
+func listCommandFunction(cmd *cobra.Command, _ []string) {
+	requireArgs("binpath")
+
+	if listFormat != "table" && listFormat != "json" {
+		fatal(fmt.Errorf("unknown format %q (table, json)", listFormat))
+	}
+
+	var prefix string
+	if cmd.Flags().Changed("prefix") {
+		prefix = args.Prefix
+	}
+	groups := managedGroups(prefix)
+
+	if listFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(groups); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "PREFIX\tCONTAINER\tNAME\tTARGET\tGENERATED")
+	for _, group := range groups {
+		for _, listed := range group.Shims {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", group.Prefix, group.Container,
+				listed.Name, listed.Target, listed.Generated.Format("2006-01-02 15:04"))
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		fatal(err)
+	}
+}
+
+// Reads the shims of every prefix directory in the bin directory, or
+// only of prefix if it is not empty
+func managedGroups(prefix string) []*listedGroup {
+	entries, err := os.ReadDir(args.BinPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	groups := []*listedGroup{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if prefix != "" && entry.Name() != prefix {
+			continue
+		}
+
+		dir := filepath.Join(args.BinPath, entry.Name())
+		if isManagedDir(dir) {
+			groups = append(groups, listDir(entry.Name(), dir)...)
+		}
+	}
+
+	return groups
+}
+
+// Reads the shims of a prefix directory grouped by their container
+func listDir(prefix string, dir string) []*listedGroup {
+	var groups []*listedGroup
+	byContainer := make(map[string]*listedGroup)
+
+	add := func(name string, info shim.Info, generated time.Time) {
+		key := info.Runtime + "/" + info.Container
+		group, ok := byContainer[key]
+		if !ok {
+			group = &listedGroup{
+				Prefix:    prefix,
+				Path:      dir,
+				Container: info.Container,
+				Runtime:   info.Runtime,
+			}
+			byContainer[key] = group
+			groups = append(groups, group)
+		}
+
+		group.Shims = append(group.Shims, listedShim{
+			Name:      name,
+			Target:    info.Target,
+			Generated: generated,
+		})
+	}
+
+	if manifest.Exists(dir) {
+		shimManifest := readManifest(dir)
+
+		names := make([]string, 0, len(shimManifest.Shims))
+		for name := range shimManifest.Shims {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			entry := shimManifest.Shims[name]
+			add(name, shim.Info{
+				Container: entry.Container,
+				Runtime:   entry.Runtime,
+				Target:    entry.Target,
+			}, entry.Generated)
+		}
+
+		return groups
+	}
+
+	// directories generated before the manifest existed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fatal(err)
+	}
+
+	dispatchManifest, err := dispatch.ReadManifest(dir)
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		var info shim.Info
+		if dispatchEntry, ok := dispatchManifest[entry.Name()]; ok {
+			info = shim.Info{
+				Container: dispatchEntry.Container,
+				Runtime:   dispatchEntry.Runtime,
+				Target:    dispatchEntry.Target,
+			}
+		} else {
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				fatal(err)
+			}
+
+			if info, ok = shim.Parse(data); !ok {
+				continue
+			}
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			fatal(err)
+		}
+
+		add(entry.Name(), info, fileInfo.ModTime())
+	}
+
+	return groups
+}
@@ -0,0 +1,65 @@
+/*
+ * Locking of prefix directories so that two runs of btb, eg. from a timer
+ * and by hand, do not change the same one at the same time. The lock is
+ * taken on a file in $XDG_STATE_HOME/btb since the directory itself is
+ * replaced while staging.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Takes the lock of binPath, waiting for another run holding it, and
+// returns the function releasing it. The lock is also released on exit.
+func lockPrefixDir(binPath string) func() {
+	requireRoot()
+	if !dirExists(filepath.Dir(binPath)) {
+		return func() {}
+	}
+
+	stateDir, err := manifest.StateDir()
+	if err != nil {
+		fatal(err)
+	}
+
+	key, err := manifest.Key(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	lockDir := filepath.Join(stateDir, "locks")
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		fatal(err)
+	}
+
+	lockPath := filepath.Join(lockDir, key+".lock")
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		fatal(err)
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		logInfo("Waiting for another btb run using %s", binPath)
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		fatal(fmt.Errorf("locking %s: %w", lockPath, err))
+	}
+
+	return func() {
+		if err := file.Close(); err != nil {
+			fatal(err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Leveled output. Progress goes to stdout unless --quiet is given, more
+ * detail with --verbose, and the commands btb runs with --debug. Warnings
+ * and errors always go to stderr, errors through fatal, see cmd/errors.go.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/shim"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+type logLevel int
+
+const (
+	levelQuiet logLevel = iota
+	levelInfo
+	levelVerbose
+	levelDebug
+)
+
+var level = levelInfo
+
+// Where progress messages go, stderr when stdout is for --output json
+var logWriter io.Writer = os.Stdout
+
+var quietFlag, verboseFlag, debugFlag bool
+
+func init() {
+	log.SetFlags(0)
+	log.SetPrefix("btb: ")
+
+	rootCmd.PersistentFlags().BoolVarP(&quietFlag, "quiet", "q", false, "only print warnings and errors")
+	rootCmd.PersistentFlags().BoolVarP(&verboseFlag, "verbose", "v", false, "print more about what is done")
+	rootCmd.PersistentFlags().BoolVarP(&debugFlag, "debug", "", false,
+		"also print every command run, implies --verbose")
+}
+
+// Sets the level from --quiet, --verbose, and --debug
+func setLogLevel() {
+	switch {
+	case debugFlag:
+		level = levelDebug
+		log.SetFlags(log.Ltime | log.Lmicroseconds)
+	case verboseFlag:
+		level = levelVerbose
+	case quietFlag:
+		level = levelQuiet
+	}
+
+	if quietFlag && (verboseFlag || debugFlag) {
+		fatal(errors.New("--quiet cannot be used with --verbose or --debug"))
+	}
+}
+
+// Prints a progress message unless --quiet was given
+func logInfo(format string, a ...interface{}) {
+	if level >= levelInfo {
+		clearProgress()
+		fmt.Fprintf(logWriter, format+"\n", a...)
+	}
+}
+
+// Prints a message with --verbose
+func logVerbose(format string, a ...interface{}) {
+	if level >= levelVerbose {
+		fmt.Fprintf(logWriter, format+"\n", a...)
+	}
+}
+
+// Prints a message with --debug
+func logDebug(format string, a ...interface{}) {
+	if level >= levelDebug {
+		log.Printf("debug: "+format, a...)
+	}
+}
+
+func logWarning(format string, a ...interface{}) {
+	clearProgress()
+	log.Printf("warning: "+format, a...)
+}
+
+// Prints a command about to be run with --debug
+func logCommand(command []string) {
+	logDebug("running %s", shim.QuoteAll(command))
+}
@@ -0,0 +1,92 @@
+/*
+ * Bookkeeping of the manifest of a prefix directory. See pkg/manifest.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"errors"
+	"os"
+	"time"
+)
+
+// Reads the manifest of binPath or starts a new one if there is none
+func readManifest(binPath string) *manifest.Manifest {
+	shimManifest, err := manifest.Read(binPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest.New(args.Prefix, args.ShimMode)
+	} else if err != nil {
+		fatal(err)
+	}
+
+	return shimManifest
+}
+
+// Records targets keyed by shim name with what was written for each of
+// them, ie. the script or the link target. Shims that did not change
+// keep the generation time from the previous manifest and skipped shims
+// keep their previous entry entirely, as do exported desktop entries, man
+// pages, completions, and D-Bus services. Shims written as the name of
+// another shim are aliases linking to it.
+func writeManifest(rt runtime.Runtime, binPath string, previous *manifest.Manifest,
+	targets map[string]string, written map[string]string, skipped map[string]bool) {
+	shimManifest := manifest.New(args.Prefix, args.ShimMode)
+	shimManifest.Desktop = previous.Desktop
+	shimManifest.ManPages = previous.ManPages
+	shimManifest.Completions = previous.Completions
+	shimManifest.DBusServices = previous.DBusServices
+	now := time.Now()
+	for fileName, target := range targets {
+		hash := manifest.Hash([]byte(written[fileName]))
+
+		generated := now
+		if old, ok := previous.Shims[fileName]; ok && old.Hash == hash {
+			generated = old.Generated
+		}
+
+		var alias string
+		if _, ok := targets[written[fileName]]; ok {
+			alias = written[fileName]
+		}
+
+		shimManifest.Shims[fileName] = manifest.Shim{
+			Container: args.Container,
+			Runtime:   rt.Name(),
+			Target:    target,
+			Hash:      hash,
+			Generated: generated,
+			Alias:     alias,
+		}
+	}
+
+	for fileName := range skipped {
+		if old, ok := previous.Shims[fileName]; ok {
+			shimManifest.Shims[fileName] = old
+		}
+	}
+
+	if err := shimManifest.Write(binPath); err != nil {
+		fatal(err)
+	}
+}
+
+// Removes the files of a manifest keyed by path that were exported for
+// the shims in shims, or all of them if shims is nil, and drops them from
+// files
+func removeExportedFiles(files map[string]string, shims map[string]bool) {
+	for filePath, fileName := range files {
+		if shims != nil && !shims[fileName] {
+			continue
+		}
+
+		if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fatal(err)
+		}
+		delete(files, filePath)
+	}
+}
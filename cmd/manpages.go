@@ -0,0 +1,145 @@
+/*
+ * Manpages command. Copies the man pages of the exported executables out
+ * of the container named after their shims, so man PREFIX-EXE works.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var manPagesCmd = &cobra.Command{
+	Use:   "manpages",
+	Short: "Export the man pages of the executables in a container",
+	Long: `Export the man pages of the executables in a container.
+Pages in sections 1, 6, and 8 of the executables of the prefix's shims are
+copied into $XDG_DATA_HOME/man named after the shims, so sync first. man
+finds them there when ~/.local/bin is on PATH, otherwise add the directory
+to MANPATH.`,
+	Args: cobra.NoArgs,
+	Run:  manPagesCommandFunction,
+}
+
+func init() {
+	rootCmd.AddCommand(manPagesCmd)
+}
+
+// Writes a tar archive of the man pages of the executables named by the
+// arguments to stdout, or nothing if there are none. Only the first page
+// found on the container's MANPATH is taken for every section.
+const manScript = `man_path=$(manpath 2>/dev/null) || man_path=/usr/local/share/man:/usr/share/man
+for name; do
+	for section in 1 6 8; do
+		IFS=:
+		for dir in $man_path; do
+			unset IFS
+			page=$(ls -d "$dir/man$section/$name.$section" "$dir/man$section/$name.$section".* 2>/dev/null | head -n 1)
+			if [ -n "$page" ]; then
+				set -- "$@" "${page#/}"
+				break
+			fi
+		done
+		unset IFS
+	done
+	shift
+done
+[ $# -eq 0 ] && exit 0
+cd / && exec tar -chf - -- "$@"
+`
+
+func manPagesCommandFunction(cmd *cobra.Command, _ []string) {
+	requireUserInstall(cmd)
+	requireArgs("binpath", "prefix", "container")
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	defer lockPrefixDir(binPath)()
+	if !isManagedDir(binPath) || !manifest.Exists(binPath) {
+		fatal(fmt.Errorf("%s has no shims to export man pages for, run sync first", binPath))
+	}
+
+	rt := containerRuntime()
+
+	shimManifest := readManifest(binPath)
+
+	// aliases have their own pages, eg. python3 and python3.12
+	shimsByExe := make(map[string][]string)
+	for fileName, entry := range shimManifest.Shims {
+		if entry.Container == args.Container {
+			exe := filepath.Base(entry.Target)
+			shimsByExe[exe] = append(shimsByExe[exe], fileName)
+		}
+	}
+
+	exes := make([]string, 0, len(shimsByExe))
+	for exe := range shimsByExe {
+		exes = append(exes, exe)
+	}
+	sort.Strings(exes)
+
+	// before writing anything since pages keep their names between runs
+	removeExportedFiles(shimManifest.ManPages, nil)
+
+	manPath := filepath.Join(dataHome(), "man")
+	exported := make(map[string]string)
+	forEachFile(exportManPages(rt, exes), func(name string, data []byte) {
+		// eg. usr/share/man/man1/rg.1.gz
+		section := filepath.Base(filepath.Dir(name))
+		page := filepath.Base(name)
+
+		exe := manPageExe(exes, section, page)
+		if exe == "" {
+			return
+		}
+
+		dirPath := filepath.Join(manPath, section)
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			fatal(err)
+		}
+
+		for _, fileName := range shimsByExe[exe] {
+			filePath := filepath.Join(dirPath, fileName+strings.TrimPrefix(page, exe))
+			replaceFile(filePath, data)
+			exported[filePath] = fileName
+		}
+	})
+
+	shimManifest.ManPages = exported
+	if err := shimManifest.Write(binPath); err != nil {
+		fatal(err)
+	}
+
+	logInfo("Exported %d man pages to %s", len(exported), manPath)
+}
+
+func exportManPages(rt runtime.Runtime, exes []string) []byte {
+	if len(exes) == 0 {
+		return nil
+	}
+
+	return runScriptOutput(rt, args.Container, manScript, nil, exes...)
+}
+
+// Returns which of exes page in the directory of section documents, eg.
+// rg for rg.1.gz in man1, or an empty string for none of them
+func manPageExe(exes []string, section string, page string) string {
+	var found string
+	for _, exe := range exes {
+		name := exe + "." + strings.TrimPrefix(section, "man")
+		if (page == name || strings.HasPrefix(page, name+".")) && len(exe) > len(found) {
+			found = exe
+		}
+	}
+
+	return found
+}
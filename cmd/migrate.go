@@ -0,0 +1,151 @@
+/*
+ * Migrate command. Rewrites the shims an older btb generated from an
+ * older version of the templates, keeping what they run, so they get the
+ * fixes of the current ones without a sync. See shim.FormatVersion.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"btb/pkg/shim"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Rewrite the shims generated by an older version of btb",
+	Long: `Rewrite the shims of a prefix directory that were rendered from an older
+version of the shim templates, keeping the container, runtime, and target they
+run. Modified shims are handled as --on-modified says. With --dry-run only
+the shims that would be rewritten are listed.`,
+	Args: cobra.NoArgs,
+	Run:  migrateCommandFunction,
+}
+
+var migrateDryRun bool
+
+func init() {
+	addShimFlags(migrateCmd)
+	addOnModifiedFlag(migrateCmd)
+	migrateCmd.Flags().BoolVarP(&migrateDryRun, "dry-run", "", false, "only list the shims to rewrite")
+
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func migrateCommandFunction(_ *cobra.Command, _ []string) {
+	requireArgs("binpath", "prefix")
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	defer lockPrefixDir(binPath)()
+	requireManaged(binPath)
+
+	parentStat, err := os.Stat(args.BinPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	shimManifest := readManifest(binPath)
+	outdated := outdatedShims(binPath)
+	names := make([]string, 0, len(outdated))
+	for name := range outdated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if migrateDryRun {
+		for _, name := range names {
+			fmt.Printf("%s (format %d)\n", name, outdated[name].Format)
+		}
+		logInfo("%d shims to migrate to format %d", len(names), shim.FormatVersion)
+		return
+	}
+
+	modified := make(map[string]bool)
+	for name := range modifiedShims(binPath, shimManifest) {
+		if _, ok := outdated[name]; ok {
+			modified[name] = true
+		}
+	}
+	skipped := handleModifiedShims(binPath, modified)
+
+	format := args.ShimFormat
+	migrated := 0
+	for _, name := range names {
+		if skipped[name] {
+			continue
+		}
+
+		info := outdated[name]
+		rt, err := runtime.Get(info.Runtime)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", name, err))
+		}
+
+		// shims keep the format their extension says
+		args.ShimFormat = format
+		for formatName, candidate := range shim.Formats {
+			if candidate.Extension != "" && strings.HasSuffix(name, candidate.Extension) {
+				args.ShimFormat = formatName
+			}
+		}
+
+		contents := renderShim(rt, info.Container, info.Target)
+		writeShim(filepath.Join(binPath, name), contents, parentStat.Mode())
+		logVerbose("Migrated %s from format %d", name, info.Format)
+		migrated++
+
+		if entry, ok := shimManifest.Shims[name]; ok {
+			entry.Hash = manifest.Hash([]byte(contents))
+			entry.Generated = time.Now()
+			shimManifest.Shims[name] = entry
+		}
+	}
+	args.ShimFormat = format
+
+	if migrated != 0 {
+		if err := shimManifest.Write(binPath); err != nil {
+			fatal(err)
+		}
+	}
+
+	logInfo("Migrated %d shims to format %d", migrated, shim.FormatVersion)
+}
+
+// Returns the info of the script shims in binPath rendered from an older
+// version of the templates keyed by file name
+func outdatedShims(binPath string) map[string]shim.Info {
+	entries, err := os.ReadDir(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	outdated := make(map[string]shim.Info)
+	for _, entry := range entries {
+		// aliases and the shims of the symlink based modes are links
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(binPath, entry.Name()))
+		if err != nil {
+			fatal(err)
+		}
+
+		if info, ok := shim.Parse(data); ok && info.Format < shim.FormatVersion {
+			outdated[entry.Name()] = info
+		}
+	}
+
+	return outdated
+}
@@ -0,0 +1,159 @@
+/*
+ * MIME defaults. With desktop --mime-defaults the exported applications
+ * become the default for the file types of their desktop entries in
+ * mimeapps.list, so opening a file on the host runs them in the
+ * container. The types are recorded in the manifest and the defaults
+ * dropped again with the desktop entries.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultAppsGroup = "Default Applications"
+
+// Returns the MIME types of the MimeType= line of a desktop entry
+func desktopMimeTypes(lines []string) []string {
+	var mimeTypes []string
+	var group string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			group = trimmed[1 : len(trimmed)-1]
+			continue
+		}
+
+		equals := strings.IndexByte(line, '=')
+		if group != "Desktop Entry" || equals < 0 || strings.TrimSpace(line[:equals]) != "MimeType" {
+			continue
+		}
+
+		for _, mimeType := range strings.Split(line[equals+1:], ";") {
+			if mimeType = strings.TrimSpace(mimeType); mimeType != "" {
+				mimeTypes = append(mimeTypes, mimeType)
+			}
+		}
+	}
+
+	return mimeTypes
+}
+
+// Returns the mimeapps.list of the user
+func mimeAppsPath() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		fatal(err)
+	}
+
+	return filepath.Join(configDir, "mimeapps.list")
+}
+
+// Makes the desktop entries of add, keyed by MIME type, the defaults for
+// them in front of the others and drops those of remove, keyed by the
+// file name of the entry
+func updateMimeDefaults(add map[string][]string, remove map[string]bool) {
+	if len(add) == 0 && len(remove) == 0 {
+		return
+	}
+
+	filePath := mimeAppsPath()
+	data, err := os.ReadFile(filePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fatal(err)
+	}
+
+	var lines []string
+	if len(data) != 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	edited := editMimeDefaults(lines, add, remove)
+	if strings.Join(edited, "\n") == strings.Join(lines, "\n") {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		fatal(err)
+	}
+	replaceFile(filePath, []byte(strings.Join(edited, "\n")+"\n"))
+	logVerbose("Updated the default applications in %s", filePath)
+}
+
+// Returns the lines of a mimeapps.list with the defaults of add and
+// without those of remove, see updateMimeDefaults
+func editMimeDefaults(lines []string, add map[string][]string, remove map[string]bool) []string {
+	added := make(map[string]bool)
+	for _, ids := range add {
+		for _, id := range ids {
+			added[id] = true
+		}
+	}
+
+	var edited []string
+	seen := make(map[string]bool)
+	// where lines for the types not in the file yet go
+	insertAt := -1
+
+	var group string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			group = trimmed[1 : len(trimmed)-1]
+			edited = append(edited, line)
+			if group == defaultAppsGroup {
+				insertAt = len(edited)
+			}
+			continue
+		}
+
+		equals := strings.IndexByte(line, '=')
+		if group != defaultAppsGroup || equals < 0 {
+			edited = append(edited, line)
+			continue
+		}
+
+		mimeType := strings.TrimSpace(line[:equals])
+		seen[mimeType] = true
+		ids := append([]string{}, add[mimeType]...)
+		for _, id := range strings.Split(line[equals+1:], ";") {
+			if id = strings.TrimSpace(id); id != "" && !remove[id] && !added[id] {
+				ids = append(ids, id)
+			}
+		}
+
+		if len(ids) != 0 {
+			edited = append(edited, mimeType+"="+strings.Join(ids, ";")+";")
+			insertAt = len(edited)
+		}
+	}
+
+	var missing []string
+	for mimeType, ids := range add {
+		if !seen[mimeType] && len(ids) != 0 {
+			missing = append(missing, mimeType+"="+strings.Join(ids, ";")+";")
+		}
+	}
+	if len(missing) == 0 {
+		return edited
+	}
+	sort.Strings(missing)
+
+	if insertAt < 0 {
+		if len(edited) != 0 {
+			edited = append(edited, "")
+		}
+		edited = append(edited, "["+defaultAppsGroup+"]")
+		insertAt = len(edited)
+	}
+
+	return append(edited[:insertAt], append(missing, edited[insertAt:]...)...)
+}
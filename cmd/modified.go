@@ -0,0 +1,106 @@
+/*
+ * Shims changed by the user since btb generated them, ie. whose contents
+ * no longer match the hash in the manifest. Depending on --on-modified
+ * they are kept as is, overwritten, or backed up before being replaced.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Returns the names of the shims in binPath that differ from previous
+func modifiedShims(binPath string, previous *manifest.Manifest) map[string]bool {
+	modified := make(map[string]bool)
+	for fileName, entry := range previous.Shims {
+		hash, err := diskHash(filepath.Join(binPath, fileName))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			fatal(err)
+		}
+
+		if hash != entry.Hash {
+			modified[fileName] = true
+		}
+	}
+
+	return modified
+}
+
+// Applies the --on-modified policy and returns the shims to leave alone
+func handleModifiedShims(binPath string, modified map[string]bool) map[string]bool {
+	if len(modified) == 0 {
+		return nil
+	}
+
+	switch args.OnModified {
+	case "skip":
+		for fileName := range modified {
+			logInfo("Keeping modified shim %s", fileName)
+		}
+		summary.Skipped += len(modified)
+		return modified
+	case "backup":
+		backupPath := filepath.Join(args.BinPath, ".btbBackup", args.Prefix)
+		if err := os.MkdirAll(backupPath, 0755); err != nil {
+			fatal(err)
+		}
+
+		suffix := time.Now().Format("20060102-150405")
+		for fileName := range modified {
+			dest := filepath.Join(backupPath, fileName+"."+suffix)
+			backupShim(filepath.Join(binPath, fileName), dest)
+			logInfo("Backed up modified shim %s to %s", fileName, dest)
+		}
+	}
+
+	return nil
+}
+
+// Copies a shim, or the link itself for symlinks, to dest
+func backupShim(filePath string, dest string) {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		fatal(err)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(filePath)
+		if err != nil {
+			fatal(err)
+		}
+		if err := os.Symlink(link, dest); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		fatal(err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		fatal(err)
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		fatal(err)
+	}
+
+	if err := dst.Close(); err != nil {
+		fatal(err)
+	}
+}
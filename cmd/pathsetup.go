@@ -0,0 +1,150 @@
+/*
+ * Path setup command. Prints or adds the line that puts a prefix
+ * directory on PATH to the rc file of the user's shell.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var pathSetupCmd = &cobra.Command{
+	Use:   "path-setup",
+	Short: "Print the line that puts the prefix directory on PATH",
+	Long: `Print the line of the rc file of your shell that puts the prefix directory
+on PATH, or add it to the rc file with --write. With --position auto the
+directory goes in front of the others when the shim names have the prefix in
+them, and behind them otherwise so that shims do not hide host commands.`,
+	Args: cobra.NoArgs,
+	Run:  pathSetupCommandFunction,
+}
+
+var (
+	pathSetupWrite bool
+	pathPosition   string
+)
+
+func init() {
+	addNameFormatFlag(pathSetupCmd)
+	pathSetupCmd.Flags().BoolVarP(&pathSetupWrite, "write", "", false, "add the line to the rc file of your shell")
+	pathSetupCmd.Flags().StringVarP(&pathPosition, "position", "", "auto",
+		"where on PATH to put the directory (auto, first, last)")
+
+	rootCmd.AddCommand(pathSetupCmd)
+}
+
+func pathSetupCommandFunction(_ *cobra.Command, _ []string) {
+	requireArgs("binpath", "prefix")
+
+	switch pathPosition {
+	case "auto", "first", "last":
+	default:
+		fatal(fmt.Errorf("--position must be auto, first, or last, not %q", pathPosition))
+	}
+
+	dir := filepath.Join(args.BinPath, args.Prefix)
+	if !pathSetupWrite {
+		rcPath, line := pathLine(dir, pathFirst())
+		fmt.Println(line)
+		logVerbose("Add it to %s to put %s on PATH", rcPath, dir)
+		return
+	}
+
+	if onPath(dir) {
+		logInfo("%s is already on PATH", dir)
+		return
+	}
+	addToPath(dir, pathFirst(), "path-setup")
+}
+
+// Reports if the prefix directory goes in front of the other directories
+// on PATH, see --position
+func pathFirst() bool {
+	switch pathPosition {
+	case "first":
+		return true
+	case "last":
+		return false
+	}
+
+	// shims without the prefix in their names would hide host commands
+	return strings.Contains(args.NameFormat, "{prefix}")
+}
+
+// Returns the rc file of the user's shell and the line of it that adds
+// dir to PATH, in front of the other directories if first
+func pathLine(dir string, first bool) (string, string) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		fatal(err)
+	}
+
+	rcPath := filepath.Join(home, ".profile")
+	line := fmt.Sprintf("export PATH=\"$PATH:%s\"", dir)
+	if first {
+		line = fmt.Sprintf("export PATH=\"%s:$PATH\"", dir)
+	}
+
+	switch filepath.Base(os.Getenv("SHELL")) {
+	case "bash":
+		rcPath = filepath.Join(home, ".bashrc")
+	case "zsh":
+		rcPath = filepath.Join(home, ".zshrc")
+	case "fish":
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			fatal(err)
+		}
+		rcPath = filepath.Join(configDir, "fish", "config.fish")
+		line = fmt.Sprintf("fish_add_path %q", dir)
+		if !first {
+			line = fmt.Sprintf("fish_add_path --append %q", dir)
+		}
+	}
+
+	return rcPath, line
+}
+
+// Offers to add dir to PATH in the rc file of the user's shell, noting
+// the btb command that added it
+func addToPath(dir string, first bool, command string) {
+	if onPath(dir) {
+		return
+	}
+
+	rcPath, line := pathLine(dir, first)
+	if data, err := os.ReadFile(rcPath); err == nil && strings.Contains(string(data), line) {
+		return
+	}
+
+	if !confirm(fmt.Sprintf("add %s to PATH in %s", dir, rcPath)) {
+		fmt.Printf("Add %s to PATH to run the shims\n", dir)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(rcPath), 0755); err != nil {
+		fatal(err)
+	}
+
+	file, err := os.OpenFile(rcPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fatal(err)
+	}
+
+	if _, err := fmt.Fprintf(file, "\n# added by btb %s\n%s\n", command, line); err != nil {
+		fatal(err)
+	}
+
+	if err := file.Close(); err != nil {
+		fatal(err)
+	}
+	fmt.Printf("Added %s to PATH in %s, start a new shell to use it\n", dir, rcPath)
+}
@@ -0,0 +1,78 @@
+/*
+ * Progress line for long runs, eg. the scan of a toolbox with a full
+ * distribution in it. Redrawn in place on the terminal progress messages
+ * go to and left out when they do not go to one, or with --quiet or
+ * --verbose, which print every step instead.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/btb"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Time between redraws of the progress line
+const progressInterval = 100 * time.Millisecond
+
+var progressLine struct {
+	mutex sync.Mutex
+	shown bool
+	drawn time.Time
+}
+
+// Reports if the progress line is shown at all
+func progressEnabled() bool {
+	file, ok := logWriter.(*os.File)
+	if !ok || level != levelInfo {
+		return false
+	}
+
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Replaces the progress line, at most every progressInterval
+func showProgress(format string, a ...interface{}) {
+	if !progressEnabled() {
+		return
+	}
+
+	progressLine.mutex.Lock()
+	defer progressLine.mutex.Unlock()
+
+	if time.Since(progressLine.drawn) < progressInterval {
+		return
+	}
+	progressLine.drawn = time.Now()
+	progressLine.shown = true
+	fmt.Fprintf(logWriter, "\r\033[K"+format, a...)
+}
+
+// Removes the progress line so that other messages start on an empty one
+func clearProgress() {
+	progressLine.mutex.Lock()
+	defer progressLine.mutex.Unlock()
+
+	if progressLine.shown {
+		fmt.Fprint(logWriter, "\r\033[K")
+		progressLine.shown = false
+		progressLine.drawn = time.Time{}
+	}
+}
+
+// Shows the progress of a scan of the container or of btb.Generate
+func showRunProgress(progress btb.Progress) {
+	if progress.Shims != 0 {
+		showProgress("Writing shims: %d", progress.Shims)
+		return
+	}
+
+	showProgress("Scanning %s: %d directories, %d executables", args.Container, progress.Dirs, progress.Executables)
+}
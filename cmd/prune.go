@@ -0,0 +1,79 @@
+/*
+ * Prune command. Removes shims whose target is no longer in the container.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove shims whose target no longer exists in the container",
+	Args:  cobra.NoArgs,
+	Run:   pruneCommandFunction,
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+}
+
+func pruneCommandFunction(_ *cobra.Command, _ []string) {
+	requireArgs("binpath", "prefix")
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	defer lockPrefixDir(binPath)()
+	requireManaged(binPath)
+
+	shimManifest := readManifest(binPath)
+
+	removed := 0
+	removedShims := make(map[string]bool)
+	for _, group := range listDir(args.Prefix, binPath) {
+		rt, err := runtime.Get(group.Runtime)
+		if err != nil {
+			fatal(err)
+		}
+
+		var targets []string
+		for _, listed := range group.Shims {
+			targets = append(targets, listed.Target)
+		}
+
+		missing := missingTargets(rt, group.Container, targets)
+		for _, listed := range group.Shims {
+			if !missing[listed.Target] {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(binPath, listed.Name)); err != nil {
+				fatal(err)
+			}
+			delete(shimManifest.Shims, listed.Name)
+			removedShims[listed.Name] = true
+			logVerbose("Removed %s (%s)", listed.Name, listed.Target)
+			removed++
+		}
+	}
+
+	if removed != 0 && manifest.Exists(binPath) {
+		removeDesktopEntries(shimManifest, removedShims)
+		removeExportedFiles(shimManifest.ManPages, removedShims)
+		removeExportedFiles(shimManifest.Completions, removedShims)
+		removeExportedFiles(shimManifest.DBusServices, removedShims)
+
+		if err := shimManifest.Write(binPath); err != nil {
+			fatal(err)
+		}
+	}
+
+	logInfo("Pruned %d shims", removed)
+}
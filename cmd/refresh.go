@@ -0,0 +1,179 @@
+/*
+ * Refresh command. Like sync but only adds, updates, and removes the
+ * shims that changed instead of regenerating the prefix directory.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/btb"
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var refreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Incrementally update the shims for the executables in a container",
+	Args:  cobra.NoArgs,
+	Run:   refreshCommandFunction,
+}
+
+func init() {
+	addFilterFlags(refreshCmd)
+	addInteractiveFlag(refreshCmd)
+	addShimFlags(refreshCmd)
+	addShimModeFlag(refreshCmd)
+	addOnModifiedFlag(refreshCmd)
+	addJobsFlag(refreshCmd)
+	addAliasLinksFlag(refreshCmd)
+	addOnConflictFlag(refreshCmd)
+	addNameFormatFlag(refreshCmd)
+	addOutputFlag(refreshCmd)
+	addForceRefreshFlag(refreshCmd)
+	refreshCmd.Flags().BoolVarP(&syncAll, "all", "", false, "refresh every profile in the config file")
+
+	rootCmd.AddCommand(refreshCmd)
+}
+
+func refreshCommandFunction(cmd *cobra.Command, cmdArgs []string) {
+	syncIncremental = true
+	syncCommandFunction(cmd, cmdArgs)
+}
+
+func refreshShims(rt runtime.Runtime, allExe []string) error {
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	if !dirExists(binPath) {
+		return generateShims(rt, allExe)
+	}
+
+	requireManaged(binPath)
+
+	parentStat, err := os.Stat(args.BinPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	previous := readManifest(binPath)
+	skipped := handleModifiedShims(binPath, modifiedShims(binPath, previous))
+
+	// changes go to a copy of the prefix directory which then replaces it
+	newPath, oldPath := prepareStaging(binPath)
+	defer onInterrupt(func() { removePartial(newPath) })()
+	stagePrefixDir(binPath, newPath)
+	migrateMarker(rt, newPath, parentStat.Mode())
+
+	var added, updated, removed int
+	if args.ShimMode != "script" {
+		added, updated, removed = refreshLinkedShims(rt, newPath, previous, shimTargets(allExe), skipped)
+	} else {
+		added, updated, removed = refreshScriptShims(rt, newPath, previous, allExe, skipped, parentStat.Mode())
+	}
+
+	swapPrefixDir(binPath, newPath, oldPath)
+
+	summary.Created, summary.Updated, summary.Removed = added, updated, removed
+	return nil
+}
+
+func refreshScriptShims(rt runtime.Runtime, binPath string, previous *manifest.Manifest,
+	allExe []string, skipped map[string]bool, mode os.FileMode) (int, int, int) {
+	shims := desiredShims(rt, allExe)
+	aliases := aliasShims(rt, allExe)
+	for fileName, canonical := range aliases {
+		shims[fileName] = canonical
+	}
+
+	entries, err := os.ReadDir(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	var added, updated, removed int
+	writes := make(map[string]string)
+	existing := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		existing[entry.Name()] = true
+		if skipped[entry.Name()] {
+			continue
+		}
+
+		filePath := filepath.Join(binPath, entry.Name())
+		contents, ok := shims[entry.Name()]
+		if !ok {
+			if err := os.Remove(filePath); err != nil {
+				fatal(err)
+			}
+			removed++
+			continue
+		}
+
+		if canonical, ok := aliases[entry.Name()]; ok {
+			if link, err := os.Readlink(filePath); err != nil || link != canonical {
+				writeAliasLink(filePath, canonical)
+				updated++
+			}
+			continue
+		}
+
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			fatal(err)
+		}
+
+		if string(data) != contents {
+			writes[entry.Name()] = contents
+			updated++
+		}
+	}
+
+	for fileName, contents := range shims {
+		if existing[fileName] {
+			continue
+		}
+
+		if canonical, ok := aliases[fileName]; ok {
+			writeAliasLink(filepath.Join(binPath, fileName), canonical)
+		} else {
+			writes[fileName] = contents
+		}
+		added++
+	}
+
+	err = btb.WriteShims(runContext, binPath, writes, mode, args.Jobs, func(progress btb.Progress) {
+		showProgress("Writing shims: %d of %d", progress.Shims, len(writes))
+	})
+	clearProgress()
+	if runContext.Err() != nil {
+		interrupted()
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	writeManifest(rt, binPath, previous, shimTargets(allExe), shims, skipped)
+
+	return added, updated, removed
+}
+
+// Rewrites the marker of a prefix directory created by an older btb
+func migrateMarker(rt runtime.Runtime, binPath string, mode os.FileMode) {
+	marker, err := btb.ReadMarker(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	if marker.Format < btb.MarkerFormat {
+		logVerbose("updating the marker of %s to format %d", binPath, btb.MarkerFormat)
+		writeMarker(binPath, rt, mode)
+	}
+}
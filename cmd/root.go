@@ -8,39 +8,81 @@
 package cmd
 
 import (
+	"btb/pkg/btb"
+	"btb/pkg/config"
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"btb/pkg/shim"
 	"bufio"
-	"context"
 	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
-	"io/fs"
-	"log"
 	"os"
-	"os/exec"
-	"os/user"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
-	"syscall"
 	"time"
 )
 
 type Args struct {
-	BinPath     string
-	Prefix      string
-	Container   string
-	InContainer bool
+	ConfigPath      string
+	Profile         string
+	BinPath         string
+	Prefix          string
+	Container       string
+	Containers      []string
+	Image           string
+	Runtime         string
+	Yes             bool
+	Include         []string
+	Exclude         []string
+	Packages        []string
+	ScanDirs        []string
+	Interactive     bool
+	AliasLinks      bool
+	Template        string
+	ShimMode        string
+	ShimFormat      string
+	OnModified      string
+	OnConflict      string
+	NameFormat      string
+	StartTimeout    time.Duration
+	KeepAlive       time.Duration
+	HostFallback    bool
+	TranslatePaths  bool
+	KeepPrivileged  bool
+	SkipScripts     bool
+	OnlySystemPaths bool
+	OnlyUserPaths   bool
+	NeverExport     []string
+	NoDefaultExcl   bool
+	SkipHostDups    bool
+	OnUnsharedCwd   string
+	PathMap         []string
+	Env             []string
+	GUIEnv          bool
+	CommandEnv      map[string][]string
+	CommandArgs     map[string][]string
+	CommandWrapper  map[string][]string
+	Hooks           config.Hooks
+	Jobs            int
+	Timeout         time.Duration
+	Shell           string
+	ContinueOnError bool
+	InContainer     bool
+	System          bool
+	Root            string
 }
 
 func currentExePath() string {
 	currentExePath, err := os.Executable()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	currentExePath, err = filepath.EvalSymlinks(currentExePath)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	return currentExePath
@@ -50,73 +92,90 @@ func dirExists(path string) bool {
 	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
 		return false
 	} else if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	return true
 }
 
-func inPlaceReverse(arr []string) {
-	size := len(arr)
-	midPoint := size / 2
-
-	for i := 0; i < midPoint; i++ {
-		arr[i], arr[size-1-i] = arr[size-1-i], arr[i]
+// Reports if dir was created by btb
+func isManagedDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, btb.MarkerName)); errors.Is(err, os.ErrNotExist) {
+		return false
+	} else if err != nil {
+		fatal(err)
 	}
-}
-
-func canExecute(userInfo *user.User, info os.FileInfo) bool {
-	// from man chmod(1p)
-	const S_IXUSR = 0100
-	const S_IXGRP = 0010
-	const S_IXOTH = 0001
 
-	mode := info.Mode()
+	return true
+}
 
-	if (S_IXOTH & mode) != 0 {
-		return true
+// Exits unless dir was created by btb, so that btb never replaces or
+// removes a directory it does not own
+func requireManaged(dir string) {
+	if !isManagedDir(dir) {
+		fatal(fmt.Errorf("%s: %w", dir, btb.ErrNotManaged))
 	}
+}
 
-	unixInfo, ok := info.Sys().(syscall.Stat_t)
-	if !ok {
-		return false
-	}
+// Reports if name can be a prefix directory in binpath or a shim in it,
+// ie. it is not empty, has no path separator, and does not start with a
+// dot like . and .. and btb's own files
+func validName(name string) bool {
+	return name != "" && !strings.ContainsRune(name, filepath.Separator) && !strings.HasPrefix(name, ".")
+}
 
-	userGid, err := strconv.ParseUint(userInfo.Gid, 10, 32)
-	if err != nil {
-		log.Fatal(err)
-	}
+// Stdin of every prompt, one reader of its own would buffer what was
+// piped in for the next prompts
+var stdin = bufio.NewReader(os.Stdin)
 
-	if (S_IXGRP&mode) != 0 && unixInfo.Gid == uint32(userGid) {
+// Asks the user a yes or no question unless --yes was given
+func confirm(question string) bool {
+	if args.Yes {
 		return true
 	}
 
-	userId, err := strconv.ParseUint(userInfo.Uid, 10, 32)
-	if err != nil {
-		log.Fatal(err)
-	}
+	fmt.Fprintf(logWriter, "%s (y/n)? ", question)
 
-	if (S_IXUSR&mode) != 0 && unixInfo.Uid == uint32(userId) {
-		return true
-	}
+	incorrectEntryCount := 0
+	for {
+		response, err := stdin.ReadString('\n')
+		if err != nil {
+			fatal(err)
+		}
 
-	return false
+		switch strings.TrimSpace(strings.ToLower(response)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		default:
+			if incorrectEntryCount == 3 {
+				abort("Too many incorrect tries. Stopping")
+			}
+			fmt.Fprint(logWriter, "Please enter (y/n): ")
+			incorrectEntryCount++
+		}
+	}
 }
 
-const BinFormat = `#!/usr/bin/env bash
-
-toolbox run -c %s %s $@
-`
-
 var rootCmd = &cobra.Command{
-	Use:   "temp",
-	Short: "Temp",
-	Long:  `Temp`,
-	Run:   rootCommandFunction,
+	Use:     "btb",
+	Version: btb.Version,
+	Short:   "Run the executables of a container from the host",
+	Long: `btb creates shims on the host for the executables of a toolbox, distrobox,
+podman, or docker container. Each shim runs its executable inside of the
+container, so with --prefix f35 running f35-firefox runs firefox in the
+container. Shims are put in a prefix directory of --binpath, eg.
+~/.local/bin/f35/f35-firefox, which is then added to PATH.
+
+Flags not given on the command line are taken from the config file.`,
+	PersistentPreRun: loadConfig,
 }
 
 func Execute() {
-	err := rootCmd.Execute()
+	stop := handleSignals()
+	err := rootCmd.ExecuteContext(runContext)
+	stop()
 	if err != nil {
 		os.Exit(-1)
 	}
@@ -124,219 +183,562 @@ func Execute() {
 
 var args Args
 
+const defaultTimeout = 30 * time.Second
+
+var conf *config.Config
+
 func init() {
-	rootCmd.Flags().StringVarP(&args.BinPath, "binpath", "", "", "TODO")
-	rootCmd.Flags().StringVarP(&args.Prefix, "prefix", "", "", "TODO")
-	rootCmd.Flags().StringVarP(&args.Container, "container", "", "", "TODO")
-	rootCmd.Flags().BoolVarP(&args.InContainer, "in-container", "", false, "TODO")
-
-	rootCmd.MarkFlagRequired("binpath")
-	rootCmd.MarkFlagRequired("prefix")
-	rootCmd.MarkFlagRequired("container")
-}
-
-func rootCommandFunction(_ *cobra.Command, _ []string) {
-	currentExePath := currentExePath()
-
-	if !args.InContainer {
-		toolboxArgs := []string{"run", "-c", args.Container, "/usr/bin/zsh"} //, "-c"}
-		inContainer := "true"
-		programArgs := []string{
-			currentExePath,
-			"--binpath", args.BinPath,
-			"--prefix", args.Prefix,
-			"--container", args.Container,
-			"--in-container", inContainer,
-		}
-		execProgram := strings.Join(append(programArgs, "\n"), " ")
+	rootCmd.PersistentFlags().StringVarP(&args.ConfigPath, "config", "", "",
+		"config file (default $XDG_CONFIG_HOME/btb/config.yaml)")
+	rootCmd.PersistentFlags().StringVarP(&args.Profile, "profile", "", "", "config profile to use")
+	rootCmd.PersistentFlags().StringVarP(&args.BinPath, "binpath", "", "",
+		"directory the prefix directories are created in (default ~/.local/bin)")
+	rootCmd.PersistentFlags().StringVarP(&args.Prefix, "prefix", "", "",
+		"name of the prefix directory the shims are put in")
+	rootCmd.PersistentFlags().StringArrayVarP(&args.Containers, "container", "", nil,
+		"container to run executables in, repeatable for sync and refresh")
+	rootCmd.PersistentFlags().StringVarP(&args.Image, "image", "", "",
+		"image to run executables in a throwaway container of, same as --runtime podman-run --container IMAGE")
+	rootCmd.PersistentFlags().StringVarP(&args.Runtime, "runtime", "", runtime.Default,
+		fmt.Sprintf("container runtime (%s)", strings.Join(runtime.Names(), ", ")))
+	rootCmd.PersistentFlags().DurationVarP(&args.Timeout, "timeout", "", defaultTimeout,
+		"how long commands run in the container, eg. to scan it, may take")
+	rootCmd.PersistentFlags().StringVarP(&args.Shell, "shell", "", "sh",
+		"POSIX shell the scripts of btb run with in the container, eg. bash")
+	rootCmd.PersistentFlags().BoolVarP(&args.ContinueOnError, "continue-on-error", "", false,
+		"warn and keep what a failing command in the container printed, eg. when a PATH entry is unreadable")
+	rootCmd.PersistentFlags().BoolVarP(&args.System, "system", "", false,
+		"install the shims for every user in /usr/local/bin with sudo, the container must be one every user can run")
+	rootCmd.PersistentFlags().BoolVarP(&args.Yes, "yes", "y", false, "answer yes to all prompts")
+	rootCmd.PersistentFlags().BoolVarP(&args.Yes, "assume-yes", "", false, "same as --yes")
+	rootCmd.PersistentFlags().StringVarP(&args.Root, "root", "", "",
+		"run the runtime with sudo or pkexec to use the rootful containers of root, eg. --root=pkexec")
+	rootCmd.PersistentFlags().Lookup("root").NoOptDefVal = "sudo"
+	if err := rootCmd.PersistentFlags().MarkHidden("assume-yes"); err != nil {
+		fatal(err)
+	}
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30000*time.Millisecond)
+func loadConfig(cmd *cobra.Command, _ []string) {
+	setLogLevel()
 
-		cmd := exec.CommandContext(ctx, "toolbox", toolboxArgs...)
+	var err error
+	conf, err = config.Load(args.ConfigPath)
+	if err != nil {
+		fatal(err)
+	}
 
-		stdin, _ := cmd.StdinPipe()
-		stdout, _ := cmd.StdoutPipe()
+	if len(args.Containers) > 1 && cmd != syncCmd && cmd != refreshCmd {
+		fatal(errors.New("--container can only be given more than once to sync and refresh"))
+	} else if len(args.Containers) != 0 {
+		args.Container = args.Containers[0]
+	}
 
-		cmd.Stderr = os.Stderr
-		cmd.Env = os.Environ()
+	applyProfile(cmd, args.Profile)
+}
 
-		err := cmd.Start()
-		if err != nil {
-			log.Fatal(err)
+// Fills in any flags not given on the command line from a config profile
+func applyProfile(cmd *cobra.Command, name string) {
+	profile, err := conf.Resolve(name)
+	if err != nil {
+		fatal(err)
+	}
+
+	flags := cmd.Flags()
+	if !flags.Changed("system") {
+		args.System = profile.System
+	}
+	manifest.SetSystem(args.System)
+	if !flags.Changed("binpath") {
+		args.BinPath = profile.BinPath
+		if args.BinPath == "" {
+			args.BinPath = defaultBinPath()
+		}
+	}
+	if !flags.Changed("prefix") {
+		args.Prefix = profile.Prefix
+	}
+	if !flags.Changed("container") {
+		args.Container = profile.Container
+	}
+	if !flags.Changed("runtime") {
+		args.Runtime = profile.Runtime
+		if args.Runtime == "" {
+			args.Runtime = runtime.Default
 		}
+	}
+	if args.Image != "" {
+		applyImage(flags.Changed("container"), flags.Changed("runtime"))
+	}
+	if !flags.Changed("root") {
+		args.Root = profile.Root
+	}
+	applyRoot()
+	if !flags.Changed("include") {
+		args.Include = profile.Include
+	}
+	if !flags.Changed("exclude") {
+		args.Exclude = profile.Exclude
+	}
+	if !flags.Changed("package") {
+		args.Packages = profile.Packages
+	}
+	if !flags.Changed("include-privileged") {
+		args.KeepPrivileged = profile.IncludePrivileged
+	}
+	if !flags.Changed("skip-scripts") {
+		args.SkipScripts = profile.SkipScripts
+	}
+	if !flags.Changed("only-system-paths") {
+		args.OnlySystemPaths = profile.OnlySystemPaths
+	}
+	if !flags.Changed("only-user-paths") {
+		args.OnlyUserPaths = profile.OnlyUserPaths
+	}
+	args.NeverExport = profile.NeverExport
+	if !flags.Changed("no-default-excludes") {
+		args.NoDefaultExcl = profile.NoDefaultExcludes
+	}
+	if !flags.Changed("skip-host-duplicates") {
+		args.SkipHostDups = profile.SkipHostDuplicates
+	}
+	if !flags.Changed("scan-dir") {
+		args.ScanDirs = profile.ScanDirs
+	}
+	if !flags.Changed("alias-links") {
+		args.AliasLinks = profile.AliasLinks
+	}
+	if !flags.Changed("template") {
+		args.Template = profile.Template
+	}
+	if !flags.Changed("shim-format") {
+		args.ShimFormat = profile.ShimFormat
+		if args.ShimFormat == "" {
+			args.ShimFormat = "sh"
+		}
+	}
+	if !flags.Changed("on-modified") {
+		args.OnModified = profile.OnModified
+		if args.OnModified == "" {
+			args.OnModified = "backup"
+		}
+	}
+	if !flags.Changed("on-conflict") {
+		args.OnConflict = profile.OnConflict
+		if args.OnConflict == "" {
+			args.OnConflict = "warn"
+		}
+	}
+	if !flags.Changed("name-format") {
+		args.NameFormat = profile.NameFormat
+		if args.NameFormat == "" {
+			args.NameFormat = btb.DefaultNameFormat
+		}
+	}
+	if !flags.Changed("start-timeout") {
+		args.StartTimeout = profile.StartTimeout
+		if args.StartTimeout == 0 {
+			args.StartTimeout = defaultStartTimeout
+		}
+	}
+	if !flags.Changed("keep-alive") {
+		args.KeepAlive = profile.KeepAlive
+	}
+	if !flags.Changed("shell") {
+		args.Shell = profile.Shell
+		if args.Shell == "" {
+			args.Shell = "sh"
+		}
+	}
+	if !flags.Changed("host-fallback") {
+		args.HostFallback = profile.HostFallback
+	}
+	if !flags.Changed("on-unshared-cwd") {
+		args.OnUnsharedCwd = profile.OnUnsharedCwd
+		if args.OnUnsharedCwd == "" {
+			args.OnUnsharedCwd = "home"
+		}
+	}
+	if !flags.Changed("translate-paths") {
+		args.TranslatePaths = profile.TranslatePaths
+	}
+	if !flags.Changed("path-map") {
+		args.PathMap = profile.PathMap
+	}
+	if !flags.Changed("env") {
+		args.Env = profile.Env
+	}
+	if !flags.Changed("gui-env") {
+		args.GUIEnv = profile.GUIEnv
+	}
+	args.CommandEnv = profile.CommandEnv
+	args.CommandArgs = profile.CommandArgs
+	args.CommandWrapper = profile.CommandWrapper
+	args.Hooks = profile.Hooks
+	if !flags.Changed("jobs") {
+		args.Jobs = profile.Jobs
+		if args.Jobs == 0 {
+			args.Jobs = defaultJobs
+		}
+	}
+	if !flags.Changed("timeout") {
+		args.Timeout = profile.Timeout
+		if args.Timeout == 0 {
+			args.Timeout = defaultTimeout
+		}
+	}
+	if !flags.Changed("shim-mode") {
+		args.ShimMode = profile.ShimMode
+		if args.ShimMode == "" {
+			args.ShimMode = "script"
+		}
+	}
+}
 
-		stdin.Write([]byte(execProgram))
+// Runtimes that run a throwaway container of an image for every command
+var imageRuntimes = []string{"podman-run", "docker-run"}
 
-		go func() {
-			reader := bufio.NewReader(os.Stdin)
-			for {
-				data, _ := reader.ReadBytes('\n')
-				stdin.Write(data)
-			}
-		}()
-
-		go func() {
-			for {
-				// cannot use buffered reading b/c prompt for rmdir is not newline outputted
-				data := make([]byte, 4096)
-				i, err := stdout.Read(data)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				if i == 0 {
-					continue
-				}
-
-				if strings.Contains(string(data), "<<<Done>>>") {
-					stdin.Write([]byte("exit\n"))
-					return
-				} else if strings.Contains(string(data), execProgram) {
-				} else {
-					fmt.Print(string(data))
-				}
-			}
-		}()
+// Uses the image given by --image as the container
+func applyImage(containerChanged bool, runtimeChanged bool) {
+	if containerChanged {
+		fatal(errors.New("--image cannot be used with --container"))
+	}
+	args.Container = args.Image
 
-		if err := cmd.Wait(); err != nil {
-			log.Fatal(err)
+	if !runtimeChanged {
+		args.Runtime = imageRuntimes[0]
+		return
+	}
+
+	_, base := runtime.SplitRoot(args.Runtime)
+	for _, name := range imageRuntimes {
+		if base == name {
+			return
 		}
+	}
+	fatal(fmt.Errorf("--image needs a runtime that runs images (%s), not %s",
+		strings.Join(imageRuntimes, ", "), args.Runtime))
+}
 
-		cancel()
-		os.Exit(0)
-	}
-
-	pathEnv := os.Getenv("PATH")
-	paths := []string{}
-	for _, path := range strings.Split(pathEnv, ":") {
-		if dirExists(path) {
-			var isBtbPath bool
-			if err := filepath.WalkDir(path, func(_ string, dirEntry os.DirEntry, _ error) error {
-				if dirEntry.Name() != filepath.Base(path) && dirEntry.IsDir() { // do not recurse in internal dirs
-					return filepath.SkipDir
-				}
-
-				if dirEntry.Name() == ".btbMarker" {
-					isBtbPath = true
-				}
-				return nil
-			}); err != nil {
-				log.Fatal(err)
-			}
+func requireArgs(names ...string) {
+	values := map[string]string{
+		"binpath":   args.BinPath,
+		"prefix":    args.Prefix,
+		"container": args.Container,
+	}
 
-			if !isBtbPath {
-				paths = append(paths, path)
-			}
+	for _, name := range names {
+		if values[name] == "" {
+			fatal(fmt.Errorf("--%s is required (set it as a flag or in the config file)", name))
 		}
 	}
 
-	reader := bufio.NewReader(os.Stdin)
+	if values["prefix"] != "" && !validName(values["prefix"]) {
+		fatal(fmt.Errorf("--prefix %q must be a directory name not starting with a dot", values["prefix"]))
+	}
+}
 
+// Generates the shims into a sibling of the prefix directory and swaps it
+// into place so a failed run does not leave an empty prefix directory
+func generateShims(rt runtime.Runtime, allExe []string) error {
 	binPath := filepath.Join(args.BinPath, args.Prefix)
+	newPath, oldPath := prepareStaging(binPath)
+	defer onInterrupt(func() { removePartial(newPath) })()
+	previous := readManifest(binPath)
+
+	var skipped map[string]bool
 	if dirExists(binPath) {
-		fmt.Printf("rmdir: %s (y/n)? ", binPath)
+		requireManaged(binPath)
+		if !confirm(fmt.Sprintf("rmdir: %s", binPath)) {
+			abort("Cannot continue with non-empty directory")
+		}
 
-		incorrectEntryCount := 0
-	UserInputLoop:
-		for {
-			response, err := reader.ReadString('\n')
-			if err != nil {
-				log.Fatal(err)
-			}
+		skipped = handleModifiedShims(binPath, modifiedShims(binPath, previous))
+	}
 
-			switch strings.TrimSpace(strings.ToLower(response)) {
-			case "y", "yes":
-				if err := os.RemoveAll(binPath); err != nil {
-					log.Fatal(err)
-				}
-				break UserInputLoop
-			case "n", "no":
-				log.Fatal("Cannot continue with non-empty directory")
-			default:
-				if incorrectEntryCount == 3 {
-					log.Fatal("Too many incorrect tries. Stopping")
-				}
-				fmt.Print("Please enter (y/n): ")
-				incorrectEntryCount++
-			}
-		}
+	if args.ShimMode == "script" {
+		return generateScriptShims(rt, allExe, skipped)
+	}
+
+	createPrefixDir(newPath, rt)
+	linkShims(binPath, newPath, skipped)
+	writeLinkedShims(rt, newPath, previous, shimTargets(allExe), skipped)
+
+	summary.countChanges(previous, readManifest(newPath))
+	swapPrefixDir(binPath, newPath, oldPath)
+	return nil
+}
+
+// Generates script shims with pkg/btb, keeping the skipped ones
+func generateScriptShims(rt runtime.Runtime, allExe []string, skipped map[string]bool) error {
+	result, err := btb.Generate(runContext, btb.Options{
+		BinPath:   args.BinPath,
+		Prefix:    args.Prefix,
+		Container: args.Container,
+		Runtime:   rt,
+		Include:   args.Include,
+		Exclude:   args.Exclude,
+		Deny:      deniedNames(),
+		// not nil so an empty container is not scanned again
+		Executables: append([]string{}, allExe...),
+		NameFormat:  shimNameFormat(),
+		OnProgress:  showRunProgress,
+		Renderer:    shimRenderer(),
+		Jobs:        args.Jobs,
+		Aliases:     aliasShims(rt, allExe),
+		Keep:        skipped,
+		Swap: func(binPath string, newPath string, oldPath string) error {
+			var err error
+			uninterrupted(func() { err = btb.SwapDirs(binPath, newPath, oldPath) })
+			return err
+		},
+	})
+	clearProgress()
+	if runContext.Err() != nil {
+		interrupted()
+	} else if err != nil {
+		return err
 	}
 
+	summary.Created, summary.Updated, summary.Removed = len(result.Created), len(result.Updated), len(result.Removed)
+	return nil
+}
+
+// Creates a prefix directory with the same mode as the bin directory it
+// is in and returns that mode for the shims
+func createPrefixDir(binPath string, rt runtime.Runtime) os.FileMode {
 	parentStat, err := os.Stat(args.BinPath)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	if err := os.Mkdir(binPath, parentStat.Mode()); err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	btbMarkerFile, err :=
-		os.OpenFile(filepath.Join(binPath, ".btbMarker"), os.O_CREATE, parentStat.Mode())
-	if err != nil {
-		log.Fatal(err)
+	writeMarker(binPath, rt, parentStat.Mode())
+	return parentStat.Mode()
+}
+
+// Writes the marker of a prefix directory for the container, keeping
+// when it was created if it has one, eg. an empty one of an older btb
+func writeMarker(binPath string, rt runtime.Runtime, mode os.FileMode) {
+	marker := btb.NewMarker(args.Prefix, args.Container, rt.Name())
+	if err := btb.WriteMarker(binPath, marker, mode); err != nil {
+		fatal(err)
 	}
-	if err := btbMarkerFile.Close(); err != nil {
-		log.Fatal(err)
+}
+
+// Returns the path of every executable to export keyed by its name
+func exeTargets(allExe []string) map[string]string {
+	exeMap, _ := resolveExecutables(allExe)
+	return exeMap
+}
+
+// Resolves executables with the same name like the shell would, see
+// btb.Resolve
+func resolveExecutables(allExe []string) (map[string]string, map[string][]string) {
+	exeMap, shadowed, err := btb.Resolve(allExe, args.Include, args.Exclude, deniedNames())
+	if err != nil {
+		fatal(err)
 	}
-	btbMarkerFile.Close()
 
-	var allExe []string
-	inPlaceReverse(paths)
-	for _, path := range paths {
-		if err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
-			if d.Name() != filepath.Base(path) && d.IsDir() { // do not recurse in internal dirs
-				return filepath.SkipDir
-			}
+	return exeMap, shadowed
+}
 
-			if err != nil {
-				return err
-			}
+// Returns the patterns of the names never exported unless included by
+// name, the never_export list and btb.DefaultExcludes
+func deniedNames() []string {
+	denied := append([]string{}, args.NeverExport...)
+	if !args.NoDefaultExcl {
+		denied = append(denied, btb.DefaultExcludes...)
+	}
 
-			currentUser, err := user.Current()
-			if err != nil {
-				return err
-			}
+	return denied
+}
 
-			info, err := d.Info()
-			if err != nil {
-				return err
-			}
+// Prints which executable was picked for every name found more than once
+// and notes the executables the filters leave out
+func reportCollisions(allExe []string) {
+	exeMap, shadowed := resolveExecutables(allExe)
+	for _, exePath := range allExe {
+		if _, ok := exeMap[filepath.Base(exePath)]; !ok {
+			summary.omit(exePath, "left out by --include, --exclude, or the commands never exported")
+		}
+	}
 
-			if !d.IsDir() && canExecute(currentUser, info) {
-				allExe = append(allExe, p)
-			}
+	names := make([]string, 0, len(shadowed))
+	for exe := range shadowed {
+		names = append(names, exe)
+	}
+	sort.Strings(names)
 
-			return nil
-		}); err != nil {
-			log.Fatal(err)
+	for _, exe := range names {
+		logInfo("%s: using %s, shadowed %s", exe, exeMap[exe], strings.Join(shadowed[exe], ", "))
+		summary.Collisions = append(summary.Collisions, collision{exe, exeMap[exe], shadowed[exe]})
+		for _, exePath := range shadowed[exe] {
+			summary.omit(exePath, "shadowed by "+exeMap[exe])
 		}
 	}
+}
 
-	exeMap := make(map[string]string)
-	for _, exePath := range allExe {
-		exe := filepath.Base(exePath)
-		exeMap[exe] = exePath
+// Returns ~/.local/bin, which is on PATH in most distributions, or an
+// empty string without a home directory. With --system returns
+// /usr/local/bin.
+func defaultBinPath() string {
+	if args.System {
+		return systemBinPath
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".local", "bin")
+}
+
+// Returns the file name of the shim for exe from --name-format
+func shimName(exe string) string {
+	return btb.ShimName(shimNameFormat(), args.Prefix, args.Container, exe)
+}
+
+// Returns --name-format with the extension of --shim-format, eg. .cmd
+func shimNameFormat() string {
+	return args.NameFormat + shim.Formats[args.ShimFormat].Extension
+}
+
+func checkNameFormat() {
+	if !strings.Contains(args.NameFormat, "{exe}") {
+		fatal(fmt.Errorf("--name-format %q must contain {exe}", args.NameFormat))
+	}
+
+	// names starting with a dot are btb's own files
+	if strings.Contains(args.NameFormat, "/") || strings.HasPrefix(args.NameFormat, ".") {
+		fatal(fmt.Errorf("--name-format %q must be a file name not starting with a dot", args.NameFormat))
 	}
+}
+
+// Returns the target of every shim to generate keyed by file name
+func shimTargets(allExe []string) map[string]string {
+	exeMap := exeTargets(allExe)
 
+	targets := make(map[string]string, len(exeMap))
 	for exe, exePath := range exeMap {
-		fileName := fmt.Sprintf("%s-%s", args.Prefix, exe)
-		filePath := filepath.Join(binPath, fileName)
+		if escaped := btb.EscapeName(exe); escaped != exe {
+			logVerbose("Escaping the shim name of %q as %s", exe, escaped)
+		}
+		targets[shimName(exe)] = exePath
+	}
 
-		file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, parentStat.Mode())
-		if err != nil {
-			log.Fatal(err)
+	return targets
+}
+
+// Returns the contents of every shim to generate keyed by file name
+func desiredShims(rt runtime.Runtime, allExe []string) map[string]string {
+	targets := shimTargets(allExe)
+
+	shims := make(map[string]string, len(targets))
+	for fileName, exePath := range targets {
+		shims[fileName] = renderShim(rt, args.Container, exePath)
+	}
+
+	return shims
+}
+
+var renderers = make(map[string]*shim.Renderer)
+
+// Returns the renderer for the template given by --template, or of the
+// --shim-format, set up from the other shim flags
+func shimRenderer() *shim.Renderer {
+	format, ok := shim.Formats[args.ShimFormat]
+	if !ok {
+		fatal(fmt.Errorf("unknown --shim-format %q (sh, cmd, powershell)", args.ShimFormat))
+	}
+
+	key := args.ShimFormat + ":" + args.Template
+	renderer, ok := renderers[key]
+	if !ok {
+		text := format.Template
+		if args.Template != "" {
+			data, err := os.ReadFile(args.Template)
+			if err != nil {
+				fatal(err)
+			}
+			text = string(data)
 		}
 
-		fileContents := fmt.Sprintf(BinFormat, args.Container, exePath)
-		if _, err := file.WriteString(fileContents); err != nil {
-			log.Fatal(err)
+		var err error
+		renderer, err = shim.NewRenderer(text)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", args.Template, err))
 		}
+		renderers[key] = renderer
+	}
+
+	renderer.StartTimeout = args.StartTimeout
+	renderer.KeepAlive = args.KeepAlive
+	renderer.HostFallback = args.HostFallback
+	renderer.TranslatePaths = args.TranslatePaths
+	renderer.OnUnsharedCwd = args.OnUnsharedCwd
+	renderer.PathMap = args.PathMap
+	renderer.Env = shimEnv()
+	renderer.CommandEnv = args.CommandEnv
+	renderer.CommandArgs = args.CommandArgs
+	renderer.CommandWrapper = args.CommandWrapper
+
+	return renderer
+}
+
+// Returns the variables every command gets from --env and --gui-env
+func shimEnv() []string {
+	env := append([]string{}, args.Env...)
+	if args.GUIEnv {
+		env = append(env, shim.GUIEnv...)
+	}
+
+	return env
+}
 
-		if err := file.Close(); err != nil {
-			log.Fatal(err)
+// Warns about the variables of --env and command_env given as NAME that
+// the runtime cannot pass along. Those of --gui-env are left out since
+// toolbox, the runtime that is not an EnvRunner, passes the display and
+// D-Bus variables itself and shares the audio socket in XDG_RUNTIME_DIR.
+func warnDroppedEnv(rt runtime.Runtime) {
+	env := append([]string{}, args.Env...)
+	for _, commandEnv := range args.CommandEnv {
+		env = append(env, commandEnv...)
+	}
+
+	seen := make(map[string]bool)
+	var dropped []string
+	for _, name := range shim.DroppedEnv(rt, env) {
+		if !seen[name] {
+			seen[name] = true
+			dropped = append(dropped, name)
 		}
 	}
+	sort.Strings(dropped)
+
+	if len(dropped) != 0 {
+		logWarning("%s cannot pass %s along, set them with NAME=value instead",
+			rt.Name(), strings.Join(dropped, ", "))
+	}
+}
 
-	fmt.Println("<<<Done>>>")
+// Renders the shim for target with the template given by --template
+func renderShim(rt runtime.Runtime, container string, target string) string {
+	contents, err := shimRenderer().Render(rt, container, target)
+	if err != nil {
+		fatal(err)
+	}
+
+	return contents
+}
+
+// Writes a shim by replacing filePath, see btb.WriteShim
+func writeShim(filePath string, contents string, mode os.FileMode) {
+	if err := btb.WriteShim(filePath, contents, mode); err != nil {
+		fatal(err)
+	}
 }
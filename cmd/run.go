@@ -0,0 +1,51 @@
+/*
+ * Run command. Runs a command in the container the same way a shim
+ * would without generating one.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/runtime"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run [--container NAME] [--] CMD [ARGS...]",
+	Short: "Run a command in the container without a shim",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   runCommandFunction,
+}
+
+func init() {
+	// everything after CMD belongs to it
+	runCmd.Flags().SetInterspersed(false)
+	addEnvFlags(runCmd)
+
+	rootCmd.AddCommand(runCmd)
+}
+
+func runCommandFunction(_ *cobra.Command, cmdArgs []string) {
+	requireArgs("container")
+
+	rt, err := runtime.Get(args.Runtime)
+	if err != nil {
+		fatal(err)
+	}
+
+	warnDroppedEnv(rt)
+	command := append(shimRenderer().Command(rt, args.Container, cmdArgs[0]), cmdArgs[1:]...)
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		fatal(err)
+	}
+
+	logCommand(command)
+	fatal(syscall.Exec(path, command, os.Environ()))
+}
@@ -0,0 +1,478 @@
+/*
+ * Scan command and the scanning of a container's executables with shell
+ * scripts. Used by runtimes that cannot run btb inside of the container,
+ * to check on the targets of existing shims, and to query package
+ * managers.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"btb/pkg/btb"
+	"btb/pkg/runtime"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "List the executables of a container without generating shims",
+	Long: `List the executables of a container that sync would find, in PATH order,
+with the directory they are in and whether one earlier in PATH shadows them.
+With --prefix the file name of their shims is listed too, and with --owners
+the package owning them, which asks the package manager about every one of
+them. With --output json or yaml the list is for other programs to read.`,
+	Args: cobra.NoArgs,
+	Run:  scanCommandFunction,
+}
+
+var (
+	scanOutput string
+	scanOwners bool
+)
+
+func init() {
+	addFilterFlags(scanCmd)
+	addForceRefreshFlag(scanCmd)
+	scanCmd.Flags().StringVarP(&scanOutput, "output", "o", "text",
+		"format of the list (text, json, yaml), json and yaml move progress messages to stderr")
+	scanCmd.Flags().BoolVarP(&scanOwners, "owners", "", false, "also look up the package owning every executable")
+
+	rootCmd.AddCommand(scanCmd)
+}
+
+// Executable found by the scan command
+type scannedExe struct {
+	Name string `json:"name" yaml:"name"`
+	Path string `json:"path" yaml:"path"`
+	// Directory of the search path it was found in
+	Dir     string `json:"dir" yaml:"dir"`
+	Package string `json:"package,omitempty" yaml:"package,omitempty"`
+	// File name of its shim, without --prefix or when it is shadowed none
+	Shim string `json:"shim,omitempty" yaml:"shim,omitempty"`
+	// Whether one with the same name earlier in PATH runs instead
+	Shadowed bool `json:"shadowed" yaml:"shadowed"`
+}
+
+// Prints the package owning every file read from stdin as FILE<tab>PACKAGE
+// lines, or nothing without rpm or dpkg. dpkg may only know the file by
+// the path with symlinks resolved, eg. /bin/ls for /usr/bin/ls.
+const ownerScript = `if command -v rpm >/dev/null 2>&1; then
+	owner() { out=$(rpm -qf --qf '%{NAME}\n' "$1" 2>/dev/null) && printf '%s\n' "$out" | head -n 1; }
+elif command -v dpkg >/dev/null 2>&1; then
+	owner() { dpkg -S "$1" 2>/dev/null | head -n 1 | cut -d: -f1; }
+else
+	exit 0
+fi
+while IFS= read -r file; do
+	package=$(owner "$file")
+	[ -n "$package" ] || package=$(owner "$(readlink -f "$file")")
+	[ -n "$package" ] && printf '%s\t%s\n' "$file" "$package"
+done
+exit 0
+`
+
+func scanCommandFunction(_ *cobra.Command, _ []string) {
+	requireArgs("container")
+
+	switch scanOutput {
+	case "text":
+	case "json", "yaml":
+		logWriter = os.Stderr
+	default:
+		fatal(fmt.Errorf("unknown output format %q (text, json, yaml)", scanOutput))
+	}
+	if args.OnlySystemPaths && args.OnlyUserPaths {
+		fatal(errors.New("--only-system-paths cannot be used with --only-user-paths"))
+	}
+
+	rt := containerRuntime()
+	allExe, err := containerExecutables(rt)
+	if err != nil {
+		fatalScriptError(err)
+	}
+
+	owners := make(map[string]string)
+	if scanOwners && len(allExe) != 0 {
+		input := strings.NewReader(strings.Join(allExe, "\n") + "\n")
+		for _, line := range runScript(rt, args.Container, ownerScript, input) {
+			if fields := strings.SplitN(line, "\t", 2); len(fields) == 2 {
+				owners[fields[0]] = fields[1]
+			}
+		}
+	}
+
+	exeMap, _ := resolveExecutables(allExe)
+	scanned := []scannedExe{}
+	for _, exePath := range allExe {
+		name := filepath.Base(exePath)
+		used, ok := exeMap[name]
+		if !ok {
+			continue
+		}
+
+		exe := scannedExe{
+			Name:     name,
+			Path:     exePath,
+			Dir:      filepath.Dir(exePath),
+			Package:  owners[exePath],
+			Shadowed: used != exePath,
+		}
+		if args.Prefix != "" && !exe.Shadowed {
+			exe.Shim = shimName(name)
+		}
+		scanned = append(scanned, exe)
+	}
+
+	printScanned(scanned)
+}
+
+func printScanned(scanned []scannedExe) {
+	switch scanOutput {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(scanned); err != nil {
+			fatal(err)
+		}
+	case "yaml":
+		data, err := yaml.Marshal(scanned)
+		if err != nil {
+			fatal(err)
+		}
+		os.Stdout.Write(data)
+	default:
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tPATH\tPACKAGE\tSHIM")
+		for _, exe := range scanned {
+			shimColumn := exe.Shim
+			if exe.Shadowed {
+				shimColumn = "(shadowed)"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", exe.Name, exe.Path, exe.Package, shimColumn)
+		}
+		if err := writer.Flush(); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// Prints every file read from stdin that is not an executable
+const missingScript = `while read -r file; do
+	` + btb.ExecutableTest + ` || echo "$file"
+done
+`
+
+// Prints every symlink read from stdin with the file it resolves to
+const linkScript = `while read -r file; do
+	[ -L "$file" ] && printf '%s\t%s\n' "$file" "$(readlink -f "$file")"
+done
+exit 0
+`
+
+// Prints the path of the executable named by the first argument
+const resolveScript = btb.LoginPath + `command -v "$1"
+`
+
+// Runs a shell script inside of container and returns its output lines
+func runScript(rt runtime.Runtime, container string, script string, stdin io.Reader, scriptArgs ...string) []string {
+	return outputLines(runScriptOutput(rt, container, script, stdin, scriptArgs...))
+}
+
+// Runs a shell script inside of container and returns its output. When
+// btb runs inside of the container the script runs directly instead.
+func runScriptOutput(rt runtime.Runtime, container string, script string, stdin io.Reader, scriptArgs ...string) []byte {
+	ctx, cancel := context.WithTimeout(runContext, args.Timeout)
+	defer cancel()
+
+	command := btb.ScriptCommand(args.Shell, script, scriptArgs...)
+	if insideContainer(rt, container) {
+		rt = nil
+	} else {
+		command = rt.Command(container, command...)
+	}
+
+	logCommand(command)
+	output, err := btb.RunScript(ctx, rt, container, args.Shell, script, stdin, scriptArgs...)
+	checkScriptError(err)
+	logDebug("the script printed %d bytes", len(output))
+
+	return output
+}
+
+// Reports if btb runs inside of container, where the runtime is not
+// available, eg. as containerRuntime found it is. Never for runtimes on
+// another machine.
+func insideContainer(rt runtime.Runtime, container string) bool {
+	if args.InContainer {
+		return true
+	}
+	if _, ok := rt.(runtime.Remote); ok {
+		return false
+	}
+
+	// containers that do not say their name are taken to be the one meant
+	name, inside := currentContainer()
+	return inside && (name == "" || name == container)
+}
+
+// Exits on an error of a script, with its exit code if it failed, unless
+// --continue-on-error is given
+func checkScriptError(err error) {
+	if err = scriptError(err); err != nil {
+		fatalScriptError(err)
+	}
+}
+
+// Returns the error of a script to stop at, ie. nil if the script failed
+// but --continue-on-error is given. Never returns once interrupted.
+func scriptError(err error) error {
+	var commandErr *btb.CommandError
+	switch {
+	case err == nil:
+		return nil
+	case runContext.Err() != nil:
+		interrupted()
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("container scan timed out after %s, raise the limit with --timeout", args.Timeout)
+	case errors.As(err, &commandErr) && args.ContinueOnError:
+		logWarning("%s, continuing with what it printed", err)
+		return nil
+	}
+
+	return err
+}
+
+// Exits on err, with the exit code of a script that failed after printing
+// its stderr
+func fatalScriptError(err error) {
+	var commandErr *btb.CommandError
+	if errors.As(err, &commandErr) {
+		os.Stderr.WriteString(commandErr.Stderr)
+		fatal(commandErr.Err)
+	}
+
+	fatal(err)
+}
+
+func outputLines(output []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines
+}
+
+// Calls fn with the name and contents of every file in a tar archive
+// written by a script
+func forEachFile(archive []byte, fn func(name string, data []byte)) {
+	// tar -h stores files linking to the same file as hard links
+	contents := make(map[string][]byte)
+
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			fatal(err)
+		}
+
+		var data []byte
+		switch header.Typeflag {
+		case tar.TypeLink:
+			data = contents[header.Linkname]
+		case tar.TypeReg, tar.TypeRegA:
+			if data, err = io.ReadAll(reader); err != nil {
+				fatal(err)
+			}
+			contents[header.Name] = data
+		default:
+			continue
+		}
+
+		fn(header.Name, data)
+	}
+}
+
+// Returns the executables in the container in PATH order, only those
+// owned by the packages given with --package if any are, and without
+// those shims cannot run, see withoutUnrunnable, or in the directories
+// left out, see inPathKind. Unless
+// --force-refresh is given, a cached scan is used if the container did
+// not change since, see cmd/cache.go.
+func containerExecutables(rt runtime.Runtime) ([]string, error) {
+	key, cacheable := scanKey(rt)
+	if cacheable && !forceRefresh {
+		if cache, ok := readScanCache(rt, key); ok {
+			logVerbose("Using the cached scan of %s, it did not change", args.Container)
+			logProblems(cache.Problems)
+			return inPathKind(withoutUnrunnable(cache.Executables, cache.Inspection)), nil
+		}
+	}
+
+	var problems []btb.Problem
+	opts := btb.Options{
+		Container:   args.Container,
+		Runtime:     rt,
+		InContainer: args.InContainer,
+		Shell:       args.Shell,
+		ScanDirs:    args.ScanDirs,
+		Packages:    args.Packages,
+		Timeout:     args.Timeout,
+		OnError: func(err error) error {
+			if err = scriptError(err); err == nil {
+				// what a failing script printed may be incomplete
+				cacheable = false
+			}
+			return err
+		},
+		OnProblem: func(problem btb.Problem) {
+			problems = append(problems, problem)
+		},
+		OnProgress: showRunProgress,
+	}
+
+	logDebug("scanning %s for executables", args.Container)
+	allExe, err := btb.Scan(runContext, opts)
+	clearProgress()
+	if err = scriptError(err); err != nil {
+		return nil, err
+	}
+	logProblems(problems)
+
+	inspection, err := btb.Inspect(runContext, opts, allExe)
+	if err = scriptError(err); err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		writeScanCache(rt, &scanCache{Key: key, Executables: allExe, Inspection: inspection, Problems: problems})
+	}
+
+	return inPathKind(withoutUnrunnable(allExe, inspection)), nil
+}
+
+// Warns about the entries of the search path the scan skipped
+func logProblems(problems []btb.Problem) {
+	for _, problem := range problems {
+		switch problem.Kind {
+		case "dangling":
+			logVerbose("Skipping %s, it links to nothing", problem.Path)
+			summary.omit(problem.Path, "links to nothing")
+		case "unreadable":
+			logWarning("could not read %s in %s, skipping it", problem.Path, args.Container)
+		default:
+			logWarning("%s", problem.Path)
+		}
+	}
+}
+
+// Leaves out the executables of allExe of another architecture or whose
+// interpreter is missing, the privileged ones, which lose their
+// privileges when run through the runtime, unless --include-privileged is
+// given, and scripts if --skip-scripts is
+func withoutUnrunnable(allExe []string, inspection *btb.Inspection) []string {
+	if inspection == nil {
+		return allExe
+	}
+
+	skipped := make(map[string]bool)
+	skip := func(paths []string, reason string, hint string) {
+		for _, exePath := range paths {
+			skipped[exePath] = true
+			logVerbose("Skipping %s (%s)", exePath, reason)
+			summary.omit(exePath, reason)
+		}
+		if len(paths) != 0 {
+			logInfo("Skipping %d executables (%s)%s", len(paths), reason, hint)
+		}
+	}
+
+	skip(inspection.Foreign, "another architecture", "")
+	skip(inspection.NoInterpreter, "interpreter not installed", "")
+	if !args.KeepPrivileged {
+		skip(inspection.Privileged, "setuid, setgid, or capabilities", ", use --include-privileged to export them")
+	}
+	if args.SkipScripts {
+		skip(inspection.Scripts, "scripts", "")
+	}
+	if len(skipped) == 0 {
+		return allExe
+	}
+
+	kept := make([]string, 0, len(allExe))
+	for _, exePath := range allExe {
+		if !skipped[exePath] {
+			kept = append(kept, exePath)
+		}
+	}
+
+	return kept
+}
+
+// Reports if exePath is in a directory of the distribution, ie. in /usr
+// but not /usr/local, /bin, or /sbin
+func inSystemPath(exePath string) bool {
+	for _, dir := range []string{"/usr/", "/bin/", "/sbin/"} {
+		if strings.HasPrefix(exePath, dir) {
+			return !strings.HasPrefix(exePath, "/usr/local/")
+		}
+	}
+
+	return false
+}
+
+// Leaves out the executables of allExe outside of the directories of the
+// distribution with --only-system-paths and those in them with
+// --only-user-paths. Before resolving names, so that /usr/bin/vim is
+// exported when ~/.local/bin/vim is left out.
+func inPathKind(allExe []string) []string {
+	if !args.OnlySystemPaths && !args.OnlyUserPaths {
+		return allExe
+	}
+
+	kept := make([]string, 0, len(allExe))
+	for _, exePath := range allExe {
+		switch system := inSystemPath(exePath); {
+		case system && args.OnlyUserPaths:
+			summary.omit(exePath, "in a system directory, left out by --only-user-paths")
+		case !system && args.OnlySystemPaths:
+			summary.omit(exePath, "outside of the system directories, left out by --only-system-paths")
+		default:
+			kept = append(kept, exePath)
+		}
+	}
+	logVerbose("Keeping %d of %d executables by their directories", len(kept), len(allExe))
+
+	return kept
+}
+
+// Returns the targets that no longer exist or are no longer executable
+func missingTargets(rt runtime.Runtime, container string, targets []string) map[string]bool {
+	input := strings.NewReader(strings.Join(targets, "\n") + "\n")
+
+	missing := make(map[string]bool)
+	for _, target := range runScript(rt, container, missingScript, input) {
+		missing[target] = true
+	}
+
+	return missing
+}
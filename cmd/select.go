@@ -0,0 +1,153 @@
+/*
+ * Interactive selection of the executables to export.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const selectHelp = `Commands:
+  /TEXT   fuzzy search (/ alone clears the search)
+  N, N-M  toggle the numbered entries
+  a       select every shown entry
+  n       deselect every shown entry
+  d DIR   select every entry in DIR
+  l       list the shown entries
+  w       write shims for the selection
+  q       quit without writing
+`
+
+// Reports if the characters of pattern appear in order in text
+func fuzzyMatch(pattern string, text string) bool {
+	pattern = strings.ToLower(pattern)
+	text = strings.ToLower(text)
+
+	for _, char := range pattern {
+		index := strings.IndexRune(text, char)
+		if index < 0 {
+			return false
+		}
+		text = text[index+len(string(char)):]
+	}
+
+	return true
+}
+
+func selectExecutables(allExe []string) []string {
+	var candidates []string
+	for _, exePath := range exeTargets(allExe) {
+		candidates = append(candidates, exePath)
+	}
+	sort.Strings(candidates)
+
+	selected := make(map[string]bool)
+	shown := candidates
+
+	list := func() {
+		for i, exePath := range shown {
+			mark := " "
+			if selected[exePath] {
+				mark = "x"
+			}
+			fmt.Fprintf(logWriter, "%4d [%s] %s\n", i+1, mark, exePath)
+		}
+	}
+
+	fmt.Fprintf(logWriter, "Found %d executables.\n%s", len(candidates), selectHelp)
+
+	for {
+		fmt.Fprintf(logWriter, "[%d/%d selected] > ", len(selected), len(candidates))
+
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			fatal(err)
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+		case line == "q":
+			abort("Selection cancelled")
+		case line == "w":
+			var selection []string
+			for _, exePath := range candidates {
+				if selected[exePath] {
+					selection = append(selection, exePath)
+				}
+			}
+			return selection
+		case line == "l":
+			list()
+		case line == "a", line == "n":
+			for _, exePath := range shown {
+				if line == "a" {
+					selected[exePath] = true
+				} else {
+					delete(selected, exePath)
+				}
+			}
+		case strings.HasPrefix(line, "/"):
+			pattern := strings.TrimPrefix(line, "/")
+			shown = nil
+			for _, exePath := range candidates {
+				if fuzzyMatch(pattern, exePath) {
+					shown = append(shown, exePath)
+				}
+			}
+			list()
+		case strings.HasPrefix(line, "d "):
+			dir := filepath.Clean(strings.TrimSpace(strings.TrimPrefix(line, "d ")))
+			for _, exePath := range candidates {
+				if filepath.Dir(exePath) == dir {
+					selected[exePath] = true
+				}
+			}
+		default:
+			first, last, err := parseRange(line, len(shown))
+			if err != nil {
+				fmt.Fprintf(logWriter, "%s\n%s", err, selectHelp)
+				continue
+			}
+
+			for _, exePath := range shown[first-1 : last] {
+				if selected[exePath] {
+					delete(selected, exePath)
+				} else {
+					selected[exePath] = true
+				}
+			}
+		}
+	}
+}
+
+// Parses N or N-M into a one based inclusive range within [1, size]
+func parseRange(text string, size int) (int, int, error) {
+	bounds := strings.SplitN(text, "-", 2)
+
+	first, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unknown command %q", text)
+	}
+
+	last := first
+	if len(bounds) == 2 {
+		if last, err = strconv.Atoi(bounds[1]); err != nil {
+			return 0, 0, fmt.Errorf("unknown command %q", text)
+		}
+	}
+
+	if first < 1 || last > size || first > last {
+		return 0, 0, fmt.Errorf("%q is not within 1-%d", text, size)
+	}
+
+	return first, last, nil
+}
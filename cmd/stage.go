@@ -0,0 +1,75 @@
+/*
+ * Staging of prefix directories. Shims are written into a sibling of the
+ * prefix directory which then replaces it, so a run that fails partway
+ * leaves the previous shims in place.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/btb"
+	"os"
+)
+
+// Returns the staging directories of binPath after cleaning up after a
+// run that did not finish
+func prepareStaging(binPath string) (string, string) {
+	newPath, oldPath, restored, err := btb.PrepareStaging(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	if restored {
+		logInfo("Restored %s from an interrupted run", binPath)
+	}
+
+	return newPath, oldPath
+}
+
+// Fills newPath with hard links to everything in binPath so it can be
+// updated without touching binPath. Files must be replaced rather than
+// written to, see writeShim.
+func stagePrefixDir(binPath string, newPath string) {
+	binStat, err := os.Stat(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	if err := os.Mkdir(newPath, binStat.Mode()); err != nil {
+		fatal(err)
+	}
+
+	entries, err := os.ReadDir(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names[entry.Name()] = true
+		}
+	}
+
+	linkShims(binPath, newPath, names)
+}
+
+// Links the named files of one directory into another, copying symlinks
+func linkShims(from string, to string, names map[string]bool) {
+	if err := btb.LinkFiles(from, to, names); err != nil {
+		fatal(err)
+	}
+}
+
+// Replaces binPath with newPath, putting binPath back if that fails. An
+// interrupt waits for the swap to finish.
+func swapPrefixDir(binPath string, newPath string, oldPath string) {
+	var err error
+	uninterrupted(func() { err = btb.SwapDirs(binPath, newPath, oldPath) })
+	if err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,252 @@
+/*
+ * Status command. Shows for every profile whether its container is
+ * running, when it was last synced, and whether a refresh is needed.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/btb"
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type profileStatus struct {
+	Profile   string `json:"profile"`
+	Prefix    string `json:"prefix"`
+	Container string `json:"container"`
+	Runtime   string `json:"runtime"`
+	// running, stopped, exists, missing, or unknown
+	State    string     `json:"state"`
+	LastSync *time.Time `json:"last_sync"`
+	Shims    int        `json:"shims"`
+	// Shims whose target is gone from the container, nil if the container
+	// could not be checked
+	Stale *int `json:"stale"`
+	// Shims named like a command on the host PATH
+	Conflicts int  `json:"conflicts"`
+	OnPath    bool `json:"on_path"`
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the container and shims of every profile",
+	Args:  cobra.NoArgs,
+	Run:   statusCommandFunction,
+}
+
+var statusFormat string
+
+func init() {
+	statusCmd.Flags().StringVarP(&statusFormat, "format", "", "table", "output format (table, json)")
+
+	rootCmd.AddCommand(statusCmd)
+}
+
+func statusCommandFunction(cmd *cobra.Command, _ []string) {
+	if statusFormat != "table" && statusFormat != "json" {
+		fatal(fmt.Errorf("unknown format %q (table, json)", statusFormat))
+	}
+
+	names := []string{args.Profile}
+	if args.Profile == "" && len(conf.ProfileNames()) != 0 {
+		names = conf.ProfileNames()
+	}
+
+	statuses := []*profileStatus{}
+	for _, name := range names {
+		applyProfile(cmd, name)
+		requireArgs("binpath", "prefix", "container")
+		statuses = append(statuses, currentStatus(name))
+	}
+
+	if statusFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(statuses); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "PROFILE\tPREFIX\tCONTAINER\tSTATE\tLAST SYNC\tSHIMS\tSTALE\tCONFLICTS\tON PATH")
+	for _, status := range statuses {
+		lastSync, stale := "never", "?"
+		if status.LastSync != nil {
+			lastSync = status.LastSync.Format("2006-01-02 15:04")
+		}
+		if status.Stale != nil {
+			stale = strconv.Itoa(*status.Stale)
+		}
+
+		onPath := "yes"
+		if !status.OnPath {
+			onPath = "no"
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%d\t%s\n", valueOr(status.Profile, "-"), status.Prefix,
+			status.Container, status.State, lastSync, status.Shims, stale, status.Conflicts, onPath)
+	}
+	if err := writer.Flush(); err != nil {
+		fatal(err)
+	}
+
+	for _, status := range statuses {
+		if status.Stale != nil && *status.Stale != 0 {
+			logInfo("%s has stale shims, run btb refresh --prefix %s", status.Prefix, status.Prefix)
+		}
+	}
+}
+
+// Returns the status of the profile applied to args
+func currentStatus(profile string) *profileStatus {
+	rt, err := runtime.Get(args.Runtime)
+	if err != nil {
+		fatal(err)
+	}
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	status := &profileStatus{
+		Profile:   profile,
+		Prefix:    args.Prefix,
+		Container: args.Container,
+		Runtime:   rt.Name(),
+		State:     containerState(rt),
+		OnPath:    onPath(binPath),
+	}
+
+	if !dirExists(binPath) || !isManagedDir(binPath) {
+		return status
+	}
+
+	// the manifest is written by every sync and refresh
+	if manifestPath, err := manifest.Path(binPath); err != nil {
+		fatal(err)
+	} else if info, err := os.Stat(manifestPath); err == nil {
+		modTime := info.ModTime()
+		status.LastSync = &modTime
+	}
+
+	targets := make(map[string]string)
+	for _, group := range listDir(args.Prefix, binPath) {
+		if group.Container != args.Container || group.Runtime != rt.Name() {
+			continue
+		}
+
+		for _, listed := range group.Shims {
+			targets[listed.Name] = listed.Target
+		}
+	}
+	status.Shims = len(targets)
+	status.Conflicts = len(hostConflicts(targets))
+
+	if status.State != "stopped" && status.State != "missing" {
+		if stale, err := staleShims(rt, targets); err != nil {
+			logVerbose("could not check %s for stale shims: %s", args.Container, err)
+		} else {
+			status.Stale = &stale
+		}
+	}
+
+	return status
+}
+
+// Returns whether the container is running, or only if it exists for
+// runtimes that start it themselves
+func containerState(rt runtime.Runtime) string {
+	if insideContainer(rt, args.Container) {
+		return "running"
+	}
+
+	if starter, ok := rt.(runtime.Starter); ok {
+		command := starter.RunningCommand(args.Container)
+		logCommand(command)
+		output, err := exec.CommandContext(runContext, command[0], command[1:]...).Output()
+		if err == nil {
+			if strings.TrimSpace(string(output)) == "true" {
+				return "running"
+			}
+			return "stopped"
+		}
+	}
+
+	if checker, ok := rt.(runtime.Checker); ok {
+		command := checker.ExistsCommand(args.Container)
+		logCommand(command)
+		if exec.CommandContext(runContext, command[0], command[1:]...).Run() == nil {
+			return "exists"
+		}
+	}
+
+	containers, ok, err := listContainers(rt)
+	if err != nil || !ok {
+		return "unknown"
+	}
+
+	for _, container := range containers {
+		if container == args.Container {
+			return "exists"
+		}
+	}
+
+	return "missing"
+}
+
+// Returns how many of targets keyed by shim name are gone from the
+// container
+func staleShims(rt runtime.Runtime, targets map[string]string) (int, error) {
+	if len(targets) == 0 {
+		return 0, nil
+	}
+
+	var input strings.Builder
+	for _, target := range targets {
+		input.WriteString(target + "\n")
+	}
+
+	ctx, cancel := context.WithTimeout(runContext, args.Timeout)
+	defer cancel()
+
+	scriptRuntime := rt
+	if insideContainer(rt, args.Container) {
+		scriptRuntime = nil
+	}
+
+	output, err := btb.RunScript(ctx, scriptRuntime, args.Container, args.Shell, missingScript,
+		strings.NewReader(input.String()))
+	if runContext.Err() != nil {
+		interrupted()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	missing := make(map[string]bool)
+	for _, target := range outputLines(output) {
+		missing[target] = true
+	}
+
+	stale := 0
+	for _, target := range targets {
+		if missing[target] {
+			stale++
+		}
+	}
+
+	return stale, nil
+}
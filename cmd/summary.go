@@ -0,0 +1,242 @@
+/*
+ * Summary of what a sync or refresh did, printed at the end of the run
+ * or as JSON with --output json, and the report of the executables that
+ * got no shim with --report.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/manifest"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type collision struct {
+	Name     string   `json:"name"`
+	Used     string   `json:"used"`
+	Shadowed []string `json:"shadowed"`
+}
+
+// Executable of the container that got no shim and why
+type omission struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type runSummary struct {
+	Profile   string  `json:"profile,omitempty"`
+	Prefix    string  `json:"prefix"`
+	Container string  `json:"container"`
+	Created   int     `json:"created"`
+	Updated   int     `json:"updated"`
+	Removed   int     `json:"removed"`
+	Skipped   int     `json:"skipped"`
+	Elapsed   float64 `json:"elapsed_seconds"`
+	// Executables found more than once and which one the shim runs
+	Collisions []collision `json:"collisions"`
+	// Shims also generated for other containers of the same run
+	Shared []sharedShim `json:"shared,omitempty"`
+	// Executables left out by filters, inspection, collisions, and
+	// conflicts
+	Omitted []omission `json:"omitted"`
+
+	// Targets of the shims keyed by file name
+	shims map[string]string
+}
+
+type sharedShim struct {
+	Name       string   `json:"name"`
+	Containers []string `json:"containers"`
+}
+
+// Summary of the profile being synced
+var summary *runSummary
+
+var (
+	outputFormat string
+	reportPath   string
+)
+
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", "text",
+		"format of the summary at the end (text, json), json moves progress messages to stderr")
+	cmd.Flags().StringVarP(&reportPath, "report", "", "",
+		"write why executables got no shim to a file, or print it without one")
+	cmd.Flags().Lookup("report").NoOptDefVal = "-"
+}
+
+func checkOutputFormat() {
+	switch outputFormat {
+	case "text":
+	case "json":
+		logWriter = os.Stderr
+	default:
+		fatal(fmt.Errorf("unknown output format %q (text, json)", outputFormat))
+	}
+}
+
+func startSummary(profile string) *runSummary {
+	summary = &runSummary{
+		Profile:    profile,
+		Prefix:     args.Prefix,
+		Container:  args.Container,
+		Collisions: []collision{},
+		Omitted:    []omission{},
+	}
+
+	return summary
+}
+
+// Counts the shims of current that were created or updated and those of
+// previous that were removed, going by what was written for each of them
+func (s *runSummary) countChanges(previous *manifest.Manifest, current *manifest.Manifest) {
+	for fileName, entry := range current.Shims {
+		if old, ok := previous.Shims[fileName]; !ok {
+			s.Created++
+		} else if old.Hash != entry.Hash || old.Container != entry.Container || old.Runtime != entry.Runtime {
+			s.Updated++
+		}
+	}
+
+	for fileName := range previous.Shims {
+		if _, ok := current.Shims[fileName]; !ok {
+			s.Removed++
+		}
+	}
+}
+
+// Notes that exePath got no shim. Does nothing without a summary, eg. for
+// diff.
+func (s *runSummary) omit(exePath string, reason string) {
+	if s != nil {
+		s.Omitted = append(s.Omitted, omission{exePath, reason})
+	}
+}
+
+// Notes the executables of before missing from after
+func (s *runSummary) omitDropped(before []string, after []string, reason string) {
+	kept := make(map[string]bool, len(after))
+	for _, exePath := range after {
+		kept[exePath] = true
+	}
+
+	for _, exePath := range before {
+		if !kept[exePath] {
+			s.omit(exePath, reason)
+		}
+	}
+}
+
+func (s *runSummary) finish(start time.Time) {
+	s.Elapsed = time.Since(start).Seconds()
+
+	logInfo("Created %d, updated %d, removed %d, skipped %d shims in %.1fs",
+		s.Created, s.Updated, s.Removed, s.Skipped, s.Elapsed)
+}
+
+// Warns about shims named the same for more than one container, of
+// which the one in the prefix directory earlier in PATH runs
+func reportSharedShims(summaries []*runSummary) {
+	containers := make(map[string][]string)
+	for _, s := range summaries {
+		for fileName := range s.shims {
+			containers[fileName] = append(containers[fileName], s.Container)
+		}
+	}
+
+	names := make([]string, 0, len(containers))
+	for fileName, shared := range containers {
+		if len(shared) > 1 {
+			names = append(names, fileName)
+		}
+	}
+	sort.Strings(names)
+
+	for _, fileName := range names {
+		logWarning("%s is generated for %s, the first of their prefix directories in PATH runs",
+			fileName, strings.Join(containers[fileName], ", "))
+
+		for _, s := range summaries {
+			if _, ok := s.shims[fileName]; !ok {
+				continue
+			}
+
+			var others []string
+			for _, container := range containers[fileName] {
+				if container != s.Container {
+					others = append(others, container)
+				}
+			}
+			s.Shared = append(s.Shared, sharedShim{fileName, others})
+		}
+	}
+}
+
+// Prints the summaries as JSON for --output json, a list of them for --all
+func printSummaries(summaries []*runSummary, all bool) {
+	writeReport(summaries)
+
+	if outputFormat != "json" {
+		return
+	}
+
+	var value interface{} = summaries
+	if !all {
+		value = summaries[0]
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		fatal(err)
+	}
+}
+
+// Writes the executables that got no shim to --report, or prints them
+// with the progress messages if it has no file
+func writeReport(summaries []*runSummary) {
+	if reportPath == "" {
+		return
+	}
+
+	output := logWriter
+	if reportPath != "-" {
+		file, err := os.Create(reportPath)
+		if err != nil {
+			fatal(err)
+		}
+		defer file.Close()
+		output = file
+	}
+
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for i, s := range summaries {
+		if i != 0 {
+			fmt.Fprintln(writer)
+		}
+		fmt.Fprintf(writer, "# %s (%s), %d executables got no shim\n", s.Prefix, s.Container, len(s.Omitted))
+
+		omitted := append([]omission(nil), s.Omitted...)
+		sort.SliceStable(omitted, func(i, j int) bool { return omitted[i].Path < omitted[j].Path })
+		for _, entry := range omitted {
+			fmt.Fprintf(writer, "%s\t%s\n", entry.Path, entry.Reason)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		fatal(err)
+	}
+	if reportPath != "-" {
+		logInfo("Wrote the report to %s", reportPath)
+	}
+}
@@ -0,0 +1,344 @@
+/*
+ * Sync command. Generates the shims for a container.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/btb"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Generate shims for the executables in a container",
+	Args:  cobra.NoArgs,
+	Run:   syncCommandFunction,
+}
+
+var syncAll bool
+
+// Set by the refresh command to update the existing shims in place
+var syncIncremental bool
+
+func init() {
+	addFilterFlags(syncCmd)
+	addInteractiveFlag(syncCmd)
+	addShimFlags(syncCmd)
+	addShimModeFlag(syncCmd)
+	addOnModifiedFlag(syncCmd)
+	addJobsFlag(syncCmd)
+	addAliasLinksFlag(syncCmd)
+	addOnConflictFlag(syncCmd)
+	addNameFormatFlag(syncCmd)
+	addOutputFlag(syncCmd)
+	addForceRefreshFlag(syncCmd)
+	syncCmd.Flags().BoolVarP(&syncAll, "all", "", false, "sync every profile in the config file")
+
+	rootCmd.AddCommand(syncCmd)
+}
+
+const defaultStartTimeout = 30 * time.Second
+
+func addShimFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&args.Template, "template", "", "", "text/template file for the shim contents")
+	cmd.Flags().DurationVarP(&args.StartTimeout, "start-timeout", "", defaultStartTimeout,
+		"how long shims wait for a stopped podman or docker container to start, 0s to not start it")
+	cmd.Flags().DurationVarP(&args.KeepAlive, "keep-alive", "", 0,
+		"keep the container running for this long after a shim ran, eg. 10m, so commands do not wait for it to start")
+	cmd.Flags().BoolVarP(&args.HostFallback, "host-fallback", "", false,
+		"run the command from the host when the container does not exist")
+	cmd.Flags().StringVarP(&args.OnUnsharedCwd, "on-unshared-cwd", "", "home",
+		"what toolbox and distrobox shims do outside of the home directory (home, error, ignore)")
+	cmd.Flags().BoolVarP(&args.TranslatePaths, "translate-paths", "", false,
+		"translate absolute host paths given to shims for the container, eg. /tmp for toolbox")
+	cmd.Flags().StringArrayVarP(&args.PathMap, "path-map", "", nil,
+		"translate paths starting with HOST to CONTAINER with --translate-paths, as HOST=CONTAINER (repeatable)")
+	cmd.Flags().StringVarP(&args.ShimFormat, "shim-format", "", "sh",
+		"shell that runs the shims (sh, cmd, powershell), eg. cmd for WSL shims run from Windows")
+	addEnvFlags(cmd)
+}
+
+func addEnvFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&args.Env, "env", "", nil,
+		"pass an environment variable to the container, or set one with NAME=value (repeatable)")
+	cmd.Flags().BoolVarP(&args.GUIEnv, "gui-env", "", false,
+		"pass the display, D-Bus, and audio variables graphical applications need")
+}
+
+func addShimModeFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&args.ShimMode, "shim-mode", "", "script",
+		"how shims are created (script, dispatcher, symlink)")
+}
+
+func addOnModifiedFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&args.OnModified, "on-modified", "", "backup",
+		"what to do with shims changed since they were generated (skip, overwrite, backup)")
+}
+
+func addNameFormatFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&args.NameFormat, "name-format", "", btb.DefaultNameFormat,
+		"file name of the shims with {exe}, {prefix}, and {container} replaced")
+}
+
+func addOnConflictFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&args.OnConflict, "on-conflict", "", "warn",
+		"what to do with shims named like a command on the host PATH (warn, skip, overwrite)")
+}
+
+func addAliasLinksFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&args.AliasLinks, "alias-links", "", false,
+		"make shims of symlinked executables, eg. cc -> gcc, symlinks to the shim of their target")
+}
+
+const defaultJobs = 8
+
+func addJobsFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&args.Jobs, "jobs", "j", defaultJobs, "number of shims to write at once")
+}
+
+func addFilterFlags(cmd *cobra.Command) {
+	addNameFilterFlags(cmd)
+	cmd.Flags().StringArrayVarP(&args.ScanDirs, "scan-dir", "", nil,
+		"also look for executables in a directory of the container outside of PATH (repeatable)")
+	cmd.Flags().StringArrayVarP(&args.Packages, "package", "", nil,
+		"only export executables owned by a package in the container (repeatable)")
+	cmd.Flags().BoolVarP(&args.KeepPrivileged, "include-privileged", "", false,
+		"also export setuid, setgid, and capability bearing executables, which run without their privileges")
+	cmd.Flags().BoolVarP(&args.SkipScripts, "skip-scripts", "", false,
+		"do not export scripts, only compiled executables")
+	cmd.Flags().BoolVarP(&args.OnlySystemPaths, "only-system-paths", "", false,
+		"only export executables of the distribution, in /usr, /bin, or /sbin")
+	cmd.Flags().BoolVarP(&args.OnlyUserPaths, "only-user-paths", "", false,
+		"only export executables outside of the distribution's directories, eg. in ~/.local/bin or /usr/local/bin")
+}
+
+// Adds the filters of executable names, which apply to listed executables
+// too, see cmd/generate.go
+func addNameFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&args.Include, "include", "", nil,
+		"only export executables matching a glob or re:regex (repeatable)")
+	cmd.Flags().StringArrayVarP(&args.Exclude, "exclude", "", nil,
+		"do not export executables matching a glob or re:regex (repeatable)")
+	cmd.Flags().BoolVarP(&args.NoDefaultExcl, "no-default-excludes", "", false,
+		"also export shells, coreutils, sudo, and the other commands of the base system not exported by default")
+	cmd.Flags().BoolVarP(&args.SkipHostDups, "skip-host-duplicates", "", false,
+		"do not export executables named like a command on the host PATH")
+}
+
+func addInteractiveFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&args.Interactive, "interactive", "i", false,
+		"choose which executables to export from a list")
+}
+
+func syncCommandFunction(cmd *cobra.Command, _ []string) {
+	checkOutputFormat()
+
+	if len(args.Containers) > 1 {
+		syncContainers(cmd)
+		return
+	}
+
+	if !syncAll {
+		if err := syncProfile(args.Profile); err != nil {
+			fatalScriptError(err)
+		}
+		printSummaries([]*runSummary{summary}, false)
+		return
+	}
+
+	if args.Profile != "" {
+		fatal(errors.New("--all cannot be used with --profile"))
+	}
+
+	names := conf.ProfileNames()
+	if len(names) == 0 {
+		fatal(errors.New("--all requires profiles in the config file"))
+	}
+
+	var summaries []*runSummary
+	for _, name := range names {
+		logInfo("Syncing profile %s", name)
+		applyProfile(cmd, name)
+		if err := syncProfile(name); err != nil {
+			fatalScriptError(err)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	reportSharedShims(summaries)
+	printSummaries(summaries, true)
+}
+
+// Syncs every container given with --container into a prefix directory
+// of the shared binpath named after it
+func syncContainers(cmd *cobra.Command) {
+	if syncAll {
+		fatal(errors.New("--all cannot be used with more than one --container"))
+	}
+	if cmd.Flags().Changed("prefix") {
+		fatal(errors.New("--prefix cannot be used with more than one --container, " +
+			"each container gets a prefix directory named after it"))
+	}
+
+	seen := make(map[string]bool)
+	var summaries []*runSummary
+	for _, container := range args.Containers {
+		if seen[container] {
+			fatal(fmt.Errorf("--container %s is given more than once", container))
+		}
+		seen[container] = true
+
+		args.Container = container
+		args.Prefix = containerPrefix(container)
+		logInfo("Syncing container %s", container)
+		if err := syncProfile(args.Profile); err != nil {
+			fatalScriptError(err)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	reportSharedShims(summaries)
+	printSummaries(summaries, true)
+}
+
+// Returns the prefix of a container synced along with others, where
+// docker-run images like docker.io/library/fedora:39 become
+// docker.io-library-fedora-39
+func containerPrefix(container string) string {
+	return strings.NewReplacer("/", "-", ":", "-").Replace(container)
+}
+
+// Syncs the profile, or the flags if it is empty. Returns what went wrong
+// with the container, eg. when it stopped, so watch can go on.
+func syncProfile(profile string) error {
+	requireArgs("binpath", "prefix", "container")
+	if err := checkSyncArgs(); err != nil {
+		return err
+	}
+
+	defer lockPrefixDir(filepath.Join(args.BinPath, args.Prefix))()
+	start := time.Now()
+
+	rt, err := findRuntime()
+	if err != nil {
+		return err
+	}
+	warnDroppedEnv(rt)
+	startSummary(profile)
+
+	allExe := listedExecutables
+	if allExe == nil {
+		if err := runHooks(rt, "pre_scan", args.Hooks.PreScan); err != nil {
+			return err
+		}
+
+		if allExe, err = containerExecutables(rt); err != nil {
+			return err
+		}
+		logVerbose("Found %d executables in %s", len(allExe), args.Container)
+	}
+
+	reportCollisions(allExe)
+
+	if args.Interactive {
+		selected := selectExecutables(allExe)
+		summary.omitDropped(allExe, selected, "not selected")
+		allExe = selected
+	}
+
+	allExe = withoutHostDuplicates(allExe)
+	allExe = checkConflicts(allExe)
+	summary.shims = shimTargets(allExe)
+	logVerbose("Generating shims for %d executables", len(allExe))
+
+	if syncIncremental {
+		err = refreshShims(rt, allExe)
+	} else {
+		err = generateShims(rt, allExe)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := runHooks(rt, "post_generate", args.Hooks.PostGenerate); err != nil {
+		logWarning("%s", err)
+	}
+
+	summary.finish(start)
+	return nil
+}
+
+// Checks the flags of sync and refresh
+func checkSyncArgs() error {
+	switch args.ShimMode {
+	case "script", "dispatcher", "symlink":
+	default:
+		return fmt.Errorf("unknown shim mode %q (script, dispatcher, symlink)", args.ShimMode)
+	}
+
+	switch args.OnModified {
+	case "skip", "overwrite", "backup":
+	default:
+		return fmt.Errorf("unknown --on-modified policy %q (skip, overwrite, backup)", args.OnModified)
+	}
+
+	checkNameFormat()
+
+	if args.HostFallback && args.ShimMode == "symlink" {
+		return errors.New("--host-fallback is not supported with --shim-mode symlink")
+	}
+
+	switch args.OnUnsharedCwd {
+	case "home", "error", "ignore":
+	default:
+		return fmt.Errorf("unknown --on-unshared-cwd policy %q (home, error, ignore)", args.OnUnsharedCwd)
+	}
+
+	if args.TranslatePaths && args.ShimMode != "script" {
+		return errors.New("--translate-paths is only supported with --shim-mode script")
+	}
+
+	for _, mapping := range args.PathMap {
+		fields := strings.SplitN(mapping, "=", 2)
+		if len(fields) != 2 || !filepath.IsAbs(fields[0]) || !filepath.IsAbs(fields[1]) {
+			return fmt.Errorf("--path-map %q is not HOST=CONTAINER with absolute paths", mapping)
+		}
+	}
+
+	if args.ShimFormat != "sh" && (args.ShimMode != "script" || args.HostFallback || args.TranslatePaths) {
+		return fmt.Errorf("--shim-format %s only supports --shim-mode script without --host-fallback or --translate-paths",
+			args.ShimFormat)
+	}
+
+	if args.KeepAlive < 0 {
+		return fmt.Errorf("--keep-alive %s is negative", args.KeepAlive)
+	}
+	if args.KeepAlive > 0 && (args.ShimMode == "dispatcher" || args.ShimFormat != "sh") {
+		return errors.New("--keep-alive is only supported with --shim-mode script or symlink and --shim-format sh")
+	}
+
+	switch args.OnConflict {
+	case "warn", "skip", "overwrite":
+	default:
+		return fmt.Errorf("unknown --on-conflict policy %q (warn, skip, overwrite)", args.OnConflict)
+	}
+
+	if args.Jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1, got %d", args.Jobs)
+	}
+
+	if args.OnlySystemPaths && args.OnlyUserPaths {
+		return errors.New("--only-system-paths cannot be used with --only-user-paths")
+	}
+
+	return nil
+}
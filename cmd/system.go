@@ -0,0 +1,106 @@
+/*
+ * System-wide installs. With --system the prefix directories go in
+ * /usr/local/bin for every user of the machine, their manifests in
+ * /var/lib/btb, and btb runs itself again with sudo before changing them.
+ * The shims run the runtime as whoever runs them, so the container has to
+ * be one every user can run commands in, eg. a rootful docker container.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/config"
+	"btb/pkg/runtime"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	systemBinPath  = "/usr/local/bin"
+	systemCacheDir = "/var/cache"
+)
+
+// Runs btb again as root with sudo when --system is given and it is not
+// root yet, with the config file of the user
+func requireRoot() {
+	if !args.System || os.Geteuid() == 0 {
+		return
+	}
+
+	sudo, err := exec.LookPath("sudo")
+	if err != nil {
+		fatal(errors.New("--system needs root, run btb as root or install sudo"))
+	}
+
+	// root has a config file of its own
+	command := []string{sudo, "--", currentExePath()}
+	if args.ConfigPath == "" {
+		if configPath, err := config.DefaultPath(); err == nil {
+			if _, err := os.Stat(configPath); err == nil {
+				command = append(command, "--config", configPath)
+			}
+		}
+	}
+	command = append(command, os.Args[1:]...)
+
+	logInfo("Running btb with sudo to change %s", args.BinPath)
+	logCommand(command)
+	fatal(syscall.Exec(sudo, command, os.Environ()))
+}
+
+// Exits with --system for commands exporting into the home directory
+func requireUserInstall(cmd *cobra.Command) {
+	if args.System {
+		fatal(fmt.Errorf("%s exports into your home directory and does not support --system", cmd.CommandPath()))
+	}
+}
+
+// Runs the runtime with the tool given by --root, eg. sudo:toolbox for
+// --runtime toolbox --root
+func applyRoot() {
+	if args.Root == "" {
+		return
+	}
+
+	// applied again for every profile of sync and watch
+	tool, base := runtime.SplitRoot(args.Runtime)
+	if tool != "" && tool != args.Root {
+		fatal(fmt.Errorf("--root %s cannot be used with --runtime %s", args.Root, args.Runtime))
+	}
+	args.Runtime = args.Root + ":" + base
+}
+
+// Asks for the password of sudo once before running the runtime with it,
+// rather than in the middle of a scan or for every command of one
+func authenticateRoot(rt runtime.Runtime) error {
+	tool, _ := runtime.SplitRoot(rt.Name())
+	if tool == "" {
+		return nil
+	}
+
+	if _, err := exec.LookPath(tool); err != nil {
+		return fmt.Errorf("runtime %s needs %s: %w", rt.Name(), tool, err)
+	}
+
+	// pkexec asks through the polkit agent of the desktop every time
+	if tool != "sudo" || os.Geteuid() == 0 {
+		return nil
+	}
+
+	command := []string{"sudo", "-v"}
+	logCommand(command)
+	sudo := exec.Command(command[0], command[1:]...)
+	sudo.Stdin, sudo.Stdout, sudo.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := sudo.Run(); err != nil {
+		return fmt.Errorf("sudo could not authenticate you for runtime %s: %w", rt.Name(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,233 @@
+/*
+ * Systemd command. Installs a user service and timer running btb sync on
+ * a schedule so the shims follow what is installed in the containers.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var systemdCmd = &cobra.Command{
+	Use:   "systemd",
+	Short: "Keep the shims in sync with a systemd user timer",
+}
+
+var systemdInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install and start a user timer running btb sync",
+	Long: `Install and start a user timer running btb sync --yes, for every
+profile if the config file has any, with the --config and --profile given.`,
+	Args: cobra.NoArgs,
+	Run:  systemdInstallCommandFunction,
+}
+
+var systemdRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Stop and remove the user timer",
+	Args:  cobra.NoArgs,
+	Run:   systemdRemoveCommandFunction,
+}
+
+var systemdStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show if the user timer is installed and how its last run went",
+	Args:  cobra.NoArgs,
+	Run:   systemdStatusCommandFunction,
+}
+
+var timerSchedule string
+var timerOnLogin bool
+
+func init() {
+	systemdInstallCmd.Flags().StringVarP(&timerSchedule, "schedule", "", "hourly",
+		"when to sync, as a systemd OnCalendar value, eg. daily or *:0/15")
+	systemdInstallCmd.Flags().BoolVarP(&timerOnLogin, "on-login", "", false, "also sync shortly after logging in")
+
+	systemdCmd.AddCommand(systemdInstallCmd, systemdRemoveCmd, systemdStatusCmd)
+	rootCmd.AddCommand(systemdCmd)
+}
+
+const unitName = "btb-sync"
+
+const serviceUnit = `# Generated by btb systemd install
+[Unit]
+Description=Sync btb shims with their containers
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`
+
+const timerUnit = `# Generated by btb systemd install
+[Unit]
+Description=Sync btb shims with their containers on a schedule
+
+[Timer]
+OnCalendar=%s
+%sPersistent=true
+
+[Install]
+WantedBy=timers.target
+`
+
+// Returns the directory of the user's systemd units
+func unitDir() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		fatal(err)
+	}
+
+	return filepath.Join(configDir, "systemd", "user")
+}
+
+// Quotes an argument of ExecStart
+func systemdQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\$%;") {
+		return arg
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "%", "%%")
+	return `"` + replacer.Replace(arg) + `"`
+}
+
+// Runs systemctl --user, printing what it printed if it fails
+func systemctl(systemctlArgs ...string) error {
+	command := append([]string{"systemctl", "--user"}, systemctlArgs...)
+	logCommand(command)
+
+	output, err := exec.CommandContext(runContext, command[0], command[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", strings.Join(command, " "), err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func systemdInstallCommandFunction(cmd *cobra.Command, _ []string) {
+	requireUserInstall(cmd)
+	command := []string{currentExePath(), "sync", "--yes"}
+	if args.ConfigPath != "" {
+		command = append(command, "--config", args.ConfigPath)
+	}
+	if args.Profile != "" {
+		command = append(command, "--profile", args.Profile)
+	} else if len(conf.ProfileNames()) != 0 {
+		command = append(command, "--all")
+	} else {
+		requireArgs("binpath", "prefix", "container")
+		command = append(command, "--binpath", args.BinPath, "--prefix", args.Prefix,
+			"--container", args.Container, "--runtime", args.Runtime)
+	}
+
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = systemdQuote(arg)
+	}
+
+	onLogin := ""
+	if timerOnLogin {
+		// the user manager starts with the first login
+		onLogin = "OnStartupSec=1min\n"
+	}
+
+	dir := unitDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fatal(err)
+	}
+
+	replaceFile(filepath.Join(dir, unitName+".service"),
+		[]byte(fmt.Sprintf(serviceUnit, strings.Join(quoted, " "))))
+	replaceFile(filepath.Join(dir, unitName+".timer"), []byte(fmt.Sprintf(timerUnit, timerSchedule, onLogin)))
+
+	if err := systemctl("daemon-reload"); err != nil {
+		fatal(err)
+	}
+	if err := systemctl("enable", "--now", unitName+".timer"); err != nil {
+		fatal(err)
+	}
+
+	logInfo("Installed %s.timer running %s %s", unitName, strings.Join(command[1:], " "), timerSchedule)
+}
+
+func systemdRemoveCommandFunction(_ *cobra.Command, _ []string) {
+	dir := unitDir()
+	timerPath := filepath.Join(dir, unitName+".timer")
+	if !dirExists(timerPath) {
+		logInfo("%s.timer is not installed", unitName)
+		return
+	}
+
+	if err := systemctl("disable", "--now", unitName+".timer"); err != nil {
+		logWarning("%s", err)
+	}
+
+	for _, filePath := range []string{timerPath, filepath.Join(dir, unitName+".service")} {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			fatal(err)
+		}
+	}
+
+	if err := systemctl("daemon-reload"); err != nil {
+		fatal(err)
+	}
+
+	logInfo("Removed %s.timer", unitName)
+}
+
+// Returns the properties of a unit from systemctl show
+func unitProperties(unit string, names ...string) map[string]string {
+	command := []string{"systemctl", "--user", "show", unit, "--property", strings.Join(names, ",")}
+	logCommand(command)
+
+	output, err := exec.CommandContext(runContext, command[0], command[1:]...).Output()
+	properties := make(map[string]string)
+	if err != nil {
+		return properties
+	}
+
+	for _, line := range outputLines(output) {
+		if i := strings.IndexByte(line, '='); i > 0 {
+			properties[line[:i]] = line[i+1:]
+		}
+	}
+
+	return properties
+}
+
+func systemdStatusCommandFunction(_ *cobra.Command, _ []string) {
+	timerPath := filepath.Join(unitDir(), unitName+".timer")
+	if !dirExists(timerPath) {
+		fmt.Printf("%s.timer is not installed, see btb systemd install\n", unitName)
+		return
+	}
+
+	timer := unitProperties(unitName+".timer", "UnitFileState", "ActiveState", "NextElapseUSecRealtime",
+		"LastTriggerUSec")
+	service := unitProperties(unitName+".service", "Result", "ExecMainStatus", "ExecStart")
+
+	fmt.Printf("timer:    %s (%s, %s)\n", timerPath, timer["UnitFileState"], timer["ActiveState"])
+	fmt.Printf("next run: %s\n", valueOr(timer["NextElapseUSecRealtime"], "not scheduled"))
+	fmt.Printf("last run: %s\n", valueOr(timer["LastTriggerUSec"], "never"))
+	if timer["LastTriggerUSec"] != "" && timer["LastTriggerUSec"] != "n/a" {
+		fmt.Printf("result:   %s (exit status %s)\n", service["Result"], service["ExecMainStatus"])
+		fmt.Printf("          see journalctl --user -u %s.service\n", unitName)
+	}
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" || value == "n/a" || value == "0" {
+		return fallback
+	}
+
+	return value
+}
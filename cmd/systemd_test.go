@@ -0,0 +1,24 @@
+package cmd
+
+import "testing"
+
+func TestSystemdQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"sync", "sync"},
+		{"/usr/local/bin/btb", "/usr/local/bin/btb"},
+		{"", `""`},
+		{"/home/user/My Config/btb.yaml", `"/home/user/My Config/btb.yaml"`},
+		{"50%", `"50%%"`},
+		{"$HOME", `"$$HOME"`},
+		{`a"b\c`, `"a\"b\\c"`},
+	}
+
+	for _, test := range tests {
+		if got := systemdQuote(test.arg); got != test.want {
+			t.Errorf("systemdQuote(%q) = %s, want %s", test.arg, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * Verify command. Compares the shims on disk against the manifest.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/dispatch"
+	"btb/pkg/manifest"
+	"btb/pkg/runtime"
+	"btb/pkg/shim"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the shims of a prefix directory against its manifest",
+	Long: `Check the shims of a prefix directory against its manifest.
+Reports shims that were modified or deleted since btb generated them and
+files that btb did not generate.`,
+	Args: cobra.NoArgs,
+	Run:  verifyCommandFunction,
+}
+
+var verifyRepair bool
+
+func init() {
+	verifyCmd.Flags().BoolVarP(&verifyRepair, "repair", "", false, "regenerate modified and deleted shims")
+	addShimFlags(verifyCmd)
+
+	rootCmd.AddCommand(verifyCmd)
+}
+
+// Hashes what is on disk the same way as the manifest, see writeManifest
+func diskHash(filePath string) (string, error) {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(filePath)
+		if err != nil {
+			return "", err
+		}
+		return manifest.Hash([]byte(link)), nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	return manifest.Hash(data), nil
+}
+
+func verifyCommandFunction(_ *cobra.Command, _ []string) {
+	requireArgs("binpath", "prefix")
+
+	binPath := filepath.Join(args.BinPath, args.Prefix)
+	defer lockPrefixDir(binPath)()
+	requireManaged(binPath)
+
+	if !manifest.Exists(binPath) {
+		fatal(fmt.Errorf("%s has no manifest, run btb sync to create one", binPath))
+	}
+	shimManifest := readManifest(binPath)
+
+	names := make([]string, 0, len(shimManifest.Shims))
+	for name := range shimManifest.Shims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	broken := 0
+	for _, name := range names {
+		entry := shimManifest.Shims[name]
+		filePath := filepath.Join(binPath, name)
+
+		hash, err := diskHash(filePath)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("deleted: %s\n", name)
+		} else if err != nil {
+			fatal(err)
+		} else if hash != entry.Hash {
+			fmt.Printf("modified: %s\n", name)
+		} else {
+			continue
+		}
+
+		if !verifyRepair {
+			broken++
+			continue
+		}
+
+		entry.Hash = repairShim(filePath, entry)
+		entry.Generated = time.Now()
+		shimManifest.Shims[name] = entry
+		fmt.Printf("repaired: %s\n", name)
+	}
+
+	entries, err := os.ReadDir(binPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		if _, ok := shimManifest.Shims[entry.Name()]; !ok {
+			fmt.Printf("orphaned: %s\n", entry.Name())
+		}
+	}
+
+	if verifyRepair {
+		if err := shimManifest.Write(binPath); err != nil {
+			fatal(err)
+		}
+	}
+
+	if broken != 0 {
+		fmt.Printf("%d shims are modified or deleted, use --repair to regenerate them\n", broken)
+		os.Exit(1)
+	}
+}
+
+// Rewrites a shim and returns the hash of what was written
+func repairShim(filePath string, entry manifest.Shim) string {
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fatal(err)
+	}
+
+	if entry.Alias != "" {
+		if err := os.Symlink(entry.Alias, filePath); err != nil {
+			fatal(err)
+		}
+		return entry.Hash
+	}
+
+	// shims of the symlink based modes recorded the hash of their link
+	for _, linkTarget := range []string{dispatch.BinaryName, shim.LauncherName} {
+		if entry.Hash == manifest.Hash([]byte(linkTarget)) {
+			if err := os.Symlink(linkTarget, filePath); err != nil {
+				fatal(err)
+			}
+			return entry.Hash
+		}
+	}
+
+	rt, err := runtime.Get(entry.Runtime)
+	if err != nil {
+		fatal(err)
+	}
+
+	parentStat, err := os.Stat(args.BinPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	contents := renderShim(rt, entry.Container, entry.Target)
+	writeShim(filePath, contents, parentStat.Mode())
+	return manifest.Hash([]byte(contents))
+}
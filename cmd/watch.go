@@ -0,0 +1,143 @@
+/*
+ * Watch command. Polls the package databases and PATH directories of the
+ * container and refreshes the shims once they changed, so a tool
+ * installed in the container shows up on the host within seconds.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"btb/pkg/runtime"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Refresh the shims whenever packages in a container change",
+	Long: `Refresh the shims, then keep polling the package databases of rpm, dpkg,
+pacman, and apk and the PATH directories of the container, refreshing the
+shims again once they changed and settled. Runs until interrupted.`,
+	Args: cobra.NoArgs,
+	Run:  watchCommandFunction,
+}
+
+var watchInterval time.Duration
+
+func init() {
+	addFilterFlags(watchCmd)
+	addInteractiveFlag(watchCmd)
+	addShimFlags(watchCmd)
+	addShimModeFlag(watchCmd)
+	addOnModifiedFlag(watchCmd)
+	addJobsFlag(watchCmd)
+	addAliasLinksFlag(watchCmd)
+	addOnConflictFlag(watchCmd)
+	addNameFormatFlag(watchCmd)
+	addForceRefreshFlag(watchCmd)
+	watchCmd.Flags().BoolVarP(&syncAll, "all", "", false, "watch every profile in the config file")
+	watchCmd.Flags().DurationVarP(&watchInterval, "interval", "", 2*time.Second,
+		"how often to check the container for changes")
+
+	rootCmd.AddCommand(watchCmd)
+}
+
+type watchedProfile struct {
+	name string
+	rt   runtime.Runtime
+	// State the shims were last refreshed for
+	refreshed string
+	// State at the previous poll
+	seen string
+}
+
+func watchCommandFunction(cmd *cobra.Command, _ []string) {
+	if watchInterval <= 0 {
+		fatal(fmt.Errorf("--interval must be positive, got %s", watchInterval))
+	}
+
+	syncIncremental = true
+
+	names := []string{args.Profile}
+	if syncAll {
+		if args.Profile != "" {
+			fatal(errors.New("--all cannot be used with --profile"))
+		}
+
+		names = conf.ProfileNames()
+		if len(names) == 0 {
+			fatal(errors.New("--all requires profiles in the config file"))
+		}
+	}
+
+	var profiles []*watchedProfile
+	for _, name := range names {
+		if syncAll {
+			applyProfile(cmd, name)
+		}
+		requireArgs("binpath", "prefix", "container")
+
+		profile := &watchedProfile{name: name, rt: containerRuntime()}
+		refreshWatched(profile)
+		profiles = append(profiles, profile)
+	}
+
+	logInfo("Watching for package changes every %s", watchInterval)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-runContext.Done():
+			interrupted()
+		case <-ticker.C:
+		}
+
+		for _, profile := range profiles {
+			if syncAll {
+				applyProfile(cmd, profile.name)
+			}
+
+			state, err := packageState(profile.rt)
+			if err != nil {
+				logWarning("could not check %s for changes: %s", args.Container, err)
+				continue
+			}
+
+			// wait for the state to settle, eg. for dnf to finish
+			settled := state == profile.seen
+			profile.seen = state
+			if !settled || state == profile.refreshed {
+				continue
+			}
+
+			logInfo("Packages in %s changed, refreshing", args.Container)
+			refreshWatched(profile)
+		}
+	}
+}
+
+// Refreshes the shims of the profile and remembers the state they are for
+func refreshWatched(profile *watchedProfile) {
+	state, err := packageState(profile.rt)
+	if err != nil {
+		logWarning("could not check %s for changes: %s", args.Container, err)
+	}
+
+	if err := syncProfile(profile.name); err != nil {
+		logWarning("could not refresh %s, trying again: %s", args.Container, err)
+		profile.seen = state
+		return
+	}
+
+	// the hooks may have changed it, eg. by upgrading the packages
+	if after, err := packageState(profile.rt); err == nil {
+		state = after
+	}
+	profile.refreshed, profile.seen = state, state
+}
@@ -0,0 +1,54 @@
+/*
+ * Which command. Shows what a shim runs and where.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"path/filepath"
+)
+
+var whichCmd = &cobra.Command{
+	Use:   "which NAME",
+	Short: "Show the container, runtime, and target of a shim",
+	Args:  cobra.ExactArgs(1),
+	Run:   whichCommandFunction,
+}
+
+func init() {
+	rootCmd.AddCommand(whichCmd)
+}
+
+func whichCommandFunction(cmd *cobra.Command, cmdArgs []string) {
+	requireArgs("binpath")
+
+	var prefix string
+	if cmd.Flags().Changed("prefix") {
+		prefix = args.Prefix
+	}
+
+	name := cmdArgs[0]
+	var found bool
+	for _, group := range managedGroups(prefix) {
+		for _, listed := range group.Shims {
+			if listed.Name != name {
+				continue
+			}
+
+			found = true
+			fmt.Printf("%s\n", filepath.Join(group.Path, listed.Name))
+			fmt.Printf("  container: %s\n", group.Container)
+			fmt.Printf("  runtime:   %s\n", group.Runtime)
+			fmt.Printf("  target:    %s\n", listed.Target)
+		}
+	}
+
+	if !found {
+		fatal(fmt.Errorf("%s is not a shim managed by btb", name))
+	}
+}
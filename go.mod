@@ -2,4 +2,7 @@ module btb
 
 go 1.16
 
-require github.com/spf13/cobra v1.3.0
+require (
+	github.com/spf13/cobra v1.3.0
+	gopkg.in/yaml.v2 v2.4.0
+)
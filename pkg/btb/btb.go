@@ -0,0 +1,168 @@
+/*
+ * Package btb scans containers for executables and generates the shims
+ * running them, for use from other programs. The btb command adds
+ * dispatcher and symlink shims, aliases, and the like on top of it.
+ *
+ * Eg. generating shims for a toolbox container
+ *   rt, _ := runtime.Get("toolbox")
+ *   result, err := btb.Generate(ctx, btb.Options{
+ *       BinPath:   "/home/user/.local/bin",
+ *       Prefix:    "f39",
+ *       Container: "fedora-toolbox-39",
+ *       Runtime:   rt,
+ *   })
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package btb
+
+import (
+	"btb/pkg/runtime"
+	"btb/pkg/shim"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	// Directory the prefix directory is created in, eg. ~/.local/bin
+	BinPath   string
+	Prefix    string
+	Container string
+	Runtime   runtime.Runtime
+	// Runs the scan scripts directly since this is the container
+	InContainer bool
+	// POSIX shell of the container the scan scripts run with, sh if empty
+	Shell string
+	// Directories to scan besides PATH
+	ScanDirs []string
+	// Keeps only the executables owned by these packages
+	Packages []string
+	// Filters of executable names, see pkg/filter
+	Include []string
+	Exclude []string
+	// Names not exported unless an include pattern matches them, eg.
+	// DefaultExcludes
+	Deny []string
+	// File names of the shims, DefaultNameFormat if empty
+	NameFormat string
+	// Renders the shims, the default template if nil
+	Renderer *shim.Renderer
+	// Limit for every command run in the container, none if zero
+	Timeout time.Duration
+	// Called with the *CommandError of a script that failed, eg. as find
+	// could not read a directory. Returning nil keeps what it printed.
+	OnError func(err error) error
+	// Called with every entry of the search path the scan skipped, eg. a
+	// dangling symlink or a directory it cannot read
+	OnProblem func(problem Problem)
+	// Called as the scan goes through directories and executables and as
+	// shims are written, one call at a time
+	OnProgress func(progress Progress)
+	// Executables to generate shims for in PATH order, scanned if nil
+	Executables []string
+	// Shims written at once, one if zero
+	Jobs int
+	// Shims that are symlinks to another shim, eg. cc to gcc, keyed by
+	// file name
+	Aliases map[string]string
+	// File names of shims kept as they are along with their manifest
+	// entry, eg. as the user modified them
+	Keep map[string]bool
+	// Replaces the prefix directory with the one written, SwapDirs if nil
+	Swap func(binPath string, newPath string, oldPath string) error
+}
+
+type Result struct {
+	// Path of the prefix directory
+	Path string
+	// File names of the shims
+	Created []string
+	Updated []string
+	Removed []string
+	// Paths of executables shadowed by one with the same name earlier in
+	// PATH keyed by name
+	Shadowed map[string][]string
+}
+
+// Error of a command run in the container
+type CommandError struct {
+	Command []string
+	// What the command printed to stderr
+	Stderr string
+	Err    error
+}
+
+func (err *CommandError) Error() string {
+	message := fmt.Sprintf("%s: %s", err.Command[0], err.Err)
+	if stderr := strings.TrimSpace(err.Stderr); stderr != "" {
+		message += ": " + stderr
+	}
+
+	return message
+}
+
+func (err *CommandError) Unwrap() error {
+	return err.Err
+}
+
+const DefaultNameFormat = "{prefix}-{exe}"
+
+// DefaultExcludes are the commands every distribution has that the host
+// runs its own of, which are never worth exporting unless asked for by
+// name
+var DefaultExcludes = []string{
+	// shells
+	"sh", "bash", "dash", "zsh", "fish", "ksh", "mksh", "csh", "tcsh", "ash",
+	// coreutils
+	`re:^\[$`, "arch", "b2sum", "base32", "base64", "basename", "basenc", "cat", "chcon", "chgrp", "chmod",
+	"chown", "chroot", "cksum", "comm", "cp", "csplit", "cut", "date", "dd", "df", "dir", "dircolors",
+	"dirname", "du", "echo", "env", "expand", "expr", "factor", "false", "fmt", "fold", "groups", "head",
+	"hostid", "id", "install", "join", "link", "ln", "logname", "ls", "md5sum", "mkdir", "mkfifo",
+	"mknod", "mktemp", "mv", "nice", "nl", "nohup", "nproc", "numfmt", "od", "paste", "pathchk",
+	"pinky", "pr", "printenv", "printf", "ptx", "pwd", "readlink", "realpath", "rm", "rmdir",
+	"runcon", "seq", "sha1sum", "sha224sum", "sha256sum", "sha384sum", "sha512sum", "shred", "shuf",
+	"sleep", "sort", "split", "stat", "stdbuf", "stty", "sum", "sync", "tac", "tail", "tee", "test",
+	"timeout", "touch", "tr", "true", "truncate", "tsort", "tty", "uname", "unexpand", "uniq",
+	"unlink", "users", "vdir", "wc", "who", "whoami", "yes",
+	// privileges and accounts
+	"sudo", "sudoedit", "su", "doas", "pkexec", "passwd", "chsh", "chfn", "newgrp", "login",
+	// init and the system
+	"init", "systemctl", "journalctl", "loginctl", "hostnamectl", "localectl", "timedatectl",
+	"shutdown", "reboot", "halt", "poweroff", "runlevel", "telinit", "udevadm", "mount", "umount",
+	"swapon", "swapoff", "modprobe", "insmod", "rmmod", "lsmod", "depmod", "ldconfig", "sysctl",
+	"fsck*", "mkfs*",
+	// ways back to the host
+	"flatpak-spawn", "host-spawn", "toolbox", "distrobox*",
+}
+
+// ShimName returns the file name of the shim for exe from a name format
+// with {exe}, {prefix}, and {container} in it, with exe and container
+// escaped, see EscapeName
+func ShimName(format string, prefix string, container string, exe string) string {
+	return strings.NewReplacer(
+		"{exe}", EscapeName(exe),
+		"{prefix}", prefix,
+		"{container}", EscapeName(container),
+	).Replace(format)
+}
+
+// EscapeName replaces the bytes of name that are awkward in a file name
+// or on a command line, ie. spaces, unicode, shell syntax, and a leading
+// dot or dash, with %XX. % is escaped too, so names never collide.
+func EscapeName(name string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(name); i++ {
+		char := name[i]
+		if char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' ||
+			strings.IndexByte("_+@,:=", char) >= 0 || i != 0 && (char == '.' || char == '-') {
+			escaped.WriteByte(char)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", char)
+		}
+	}
+
+	return escaped.String()
+}
@@ -0,0 +1,379 @@
+/*
+ * Generation of script shims. Shims are written into a sibling of the
+ * prefix directory which then replaces it, so a run that fails partway
+ * leaves the previous shims in place. The btb command generates its
+ * script shims with Generate too.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package btb
+
+import (
+	"btb/pkg/manifest"
+	"btb/pkg/shim"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StagingDirs returns the directories the prefix directory binPath is
+// written into and moved aside to while replacing it
+func StagingDirs(binPath string) (string, string) {
+	dir, name := filepath.Split(binPath)
+	return filepath.Join(dir, "."+name+".btbNew"), filepath.Join(dir, "."+name+".btbOld")
+}
+
+// PrepareStaging returns the staging directories of binPath after
+// cleaning up after a run that did not finish. Reports if binPath was
+// restored from one that was interrupted while swapping.
+func PrepareStaging(binPath string) (string, string, bool, error) {
+	newPath, oldPath := StagingDirs(binPath)
+
+	// interrupted between moving the prefix directory away and replacing it
+	restored := false
+	if !exists(binPath) && exists(oldPath) {
+		if err := renameDir(oldPath, binPath); err != nil {
+			return "", "", false, err
+		}
+		restored = true
+	}
+
+	for _, dirPath := range []string{newPath, oldPath} {
+		if err := removeDir(dirPath); err != nil {
+			return "", "", false, err
+		}
+	}
+
+	return newPath, oldPath, restored, nil
+}
+
+// SwapDirs replaces binPath with newPath, putting binPath back if that
+// fails. Their manifests move along.
+func SwapDirs(binPath string, newPath string, oldPath string) error {
+	if !exists(binPath) {
+		return renameDir(newPath, binPath)
+	}
+
+	if err := renameDir(binPath, oldPath); err != nil {
+		return err
+	}
+
+	if err := renameDir(newPath, binPath); err != nil {
+		if restoreErr := renameDir(oldPath, binPath); restoreErr != nil {
+			return fmt.Errorf("%w, previous shims are left in %s", err, oldPath)
+		}
+		return err
+	}
+
+	return removeDir(oldPath)
+}
+
+// Renames a prefix directory along with its manifest
+func renameDir(from string, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+
+	return manifest.Rename(from, to)
+}
+
+// Removes a prefix directory along with its manifest
+func removeDir(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	return manifest.Remove(dir)
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// WriteShim writes a shim by replacing filePath, which also keeps hard
+// links to the previous file in a staged prefix directory intact
+func WriteShim(filePath string, contents string, mode os.FileMode) error {
+	tempPath := filePath + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(contents); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, filePath)
+}
+
+// LinkFiles links the named files of one directory into another,
+// copying symlinks
+func LinkFiles(from string, to string, names map[string]bool) error {
+	for fileName := range names {
+		src, dest := filepath.Join(from, fileName), filepath.Join(to, fileName)
+
+		info, err := os.Lstat(src)
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, dest); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.Link(src, dest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteShims writes shims keyed by file name into dir with jobs writers
+// at once, calling onProgress after every shim if it is not nil. Stops
+// writing when ctx is done and returns the first error.
+func WriteShims(ctx context.Context, dir string, shims map[string]string, mode os.FileMode, jobs int,
+	onProgress func(progress Progress)) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var mutex sync.Mutex
+	var firstErr error
+	var progress Progress
+	fileNames := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fileName := range fileNames {
+				err := ctx.Err()
+				if err == nil {
+					err = WriteShim(filepath.Join(dir, fileName), shims[fileName], mode)
+				}
+
+				mutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				if err == nil && onProgress != nil {
+					progress.Shims++
+					onProgress(progress)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for fileName := range shims {
+		fileNames <- fileName
+	}
+	close(fileNames)
+	wg.Wait()
+
+	return firstErr
+}
+
+// Reads the manifest of binPath, checking that btb manages it
+func previousManifest(binPath string, prefix string) (*manifest.Manifest, error) {
+	if !exists(binPath) {
+		return manifest.New(prefix, "script"), nil
+	}
+
+	if !exists(filepath.Join(binPath, MarkerName)) {
+		return nil, fmt.Errorf("%s: %w", binPath, ErrNotManaged)
+	}
+
+	previous, err := manifest.Read(binPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest.New(prefix, "script"), nil
+	}
+
+	return previous, err
+}
+
+// Generate replaces the prefix directory with script shims for the
+// executables of the container, scanning it unless opts.Executables is
+// given
+func Generate(ctx context.Context, opts Options) (*Result, error) {
+	if opts.Runtime == nil {
+		return nil, errors.New("no runtime given")
+	}
+
+	var err error
+	allExe := opts.Executables
+	if allExe == nil {
+		if allExe, err = Scan(ctx, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	exeMap, shadowed, err := Resolve(allExe, opts.Include, opts.Exclude, opts.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	nameFormat := opts.NameFormat
+	if nameFormat == "" {
+		nameFormat = DefaultNameFormat
+	}
+
+	renderer := opts.Renderer
+	if renderer == nil {
+		if renderer, err = shim.NewRenderer(""); err != nil {
+			return nil, err
+		}
+	}
+
+	binPath := filepath.Join(opts.BinPath, opts.Prefix)
+	previous, err := previousManifest(binPath, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	parentStat, err := os.Stat(opts.BinPath)
+	if err != nil {
+		return nil, err
+	}
+	mode := parentStat.Mode()
+
+	newPath, oldPath, _, err := PrepareStaging(binPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Path: binPath, Shadowed: shadowed}
+	if err := writeScriptShims(ctx, &opts, binPath, newPath, mode, renderer, nameFormat, exeMap, previous,
+		result); err != nil {
+		removeDir(newPath)
+		return nil, err
+	}
+
+	swap := opts.Swap
+	if swap == nil {
+		swap = SwapDirs
+	}
+	if err := swap(binPath, newPath, oldPath); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Writes the prefix directory with a shim for every executable in exeMap
+// and records what changed from previous in result
+func writeScriptShims(ctx context.Context, opts *Options, binPath string, newPath string, mode os.FileMode,
+	renderer *shim.Renderer, nameFormat string, exeMap map[string]string, previous *manifest.Manifest,
+	result *Result) error {
+	if err := os.Mkdir(newPath, mode); err != nil {
+		return err
+	}
+
+	marker := NewMarker(opts.Prefix, opts.Container, opts.Runtime.Name())
+	if previous, err := ReadMarker(binPath); err == nil && !previous.Created.IsZero() {
+		marker.Created = previous.Created
+	}
+	if err := WriteMarker(newPath, marker, mode); err != nil {
+		return err
+	}
+
+	if err := LinkFiles(binPath, newPath, opts.Keep); err != nil {
+		return err
+	}
+
+	targets := make(map[string]string, len(exeMap))
+	for exe, target := range exeMap {
+		targets[ShimName(nameFormat, opts.Prefix, opts.Container, exe)] = target
+	}
+
+	// what is written for every shim, the name of the shim an alias links to
+	written := make(map[string]string, len(targets))
+	writes := make(map[string]string, len(targets))
+	for fileName, target := range targets {
+		if opts.Keep[fileName] {
+			continue
+		}
+
+		if canonical, ok := opts.Aliases[fileName]; ok {
+			if err := os.Symlink(canonical, filepath.Join(newPath, fileName)); err != nil {
+				return err
+			}
+			written[fileName] = canonical
+			continue
+		}
+
+		contents, err := renderer.Render(opts.Runtime, opts.Container, target)
+		if err != nil {
+			return err
+		}
+		written[fileName] = contents
+		writes[fileName] = contents
+	}
+
+	if err := WriteShims(ctx, newPath, writes, mode, opts.Jobs, opts.OnProgress); err != nil {
+		return err
+	}
+
+	shimManifest := manifest.New(opts.Prefix, "script")
+	shimManifest.Desktop = previous.Desktop
+	shimManifest.ManPages = previous.ManPages
+	shimManifest.Completions = previous.Completions
+	shimManifest.DBusServices = previous.DBusServices
+	now := time.Now()
+	for fileName, contents := range written {
+		entry := manifest.Shim{
+			Container: opts.Container,
+			Runtime:   opts.Runtime.Name(),
+			Target:    targets[fileName],
+			Hash:      manifest.Hash([]byte(contents)),
+			Generated: now,
+		}
+		if _, ok := opts.Aliases[fileName]; ok {
+			entry.Alias = contents
+		}
+
+		if old, ok := previous.Shims[fileName]; !ok {
+			result.Created = append(result.Created, fileName)
+		} else if old.Hash != entry.Hash || old.Container != entry.Container || old.Runtime != entry.Runtime {
+			result.Updated = append(result.Updated, fileName)
+		} else {
+			entry.Generated = old.Generated
+		}
+
+		shimManifest.Shims[fileName] = entry
+	}
+
+	for fileName := range opts.Keep {
+		if old, ok := previous.Shims[fileName]; ok {
+			shimManifest.Shims[fileName] = old
+		}
+	}
+
+	for fileName := range previous.Shims {
+		if _, ok := shimManifest.Shims[fileName]; !ok {
+			result.Removed = append(result.Removed, fileName)
+		}
+	}
+
+	return shimManifest.Write(newPath)
+}
@@ -0,0 +1,209 @@
+package btb
+
+import (
+	"btb/pkg/manifest"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Keeps the manifests written by the tests out of the home directory
+func TestMain(m *testing.M) {
+	stateHome, err := os.MkdirTemp("", "btb-state")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_STATE_HOME", stateHome)
+
+	code := m.Run()
+	os.RemoveAll(stateHome)
+	os.Exit(code)
+}
+
+type fakeRuntime struct{}
+
+func (fakeRuntime) Name() string {
+	return "fake"
+}
+
+func (fakeRuntime) Command(container string, args ...string) []string {
+	return append([]string{"fake-exec", container}, args...)
+}
+
+func generate(t *testing.T, opts Options) *Result {
+	t.Helper()
+
+	result, err := Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(result.Created)
+	sort.Strings(result.Updated)
+	sort.Strings(result.Removed)
+
+	return result
+}
+
+func TestGenerate(t *testing.T) {
+	binPath := t.TempDir()
+	opts := Options{
+		BinPath:     binPath,
+		Prefix:      "f39",
+		Container:   "fedora",
+		Runtime:     fakeRuntime{},
+		Executables: []string{"/usr/local/bin/gcc", "/usr/bin/gcc", "/usr/bin/cc"},
+		Aliases:     map[string]string{"f39-cc": "f39-gcc"},
+		Jobs:        2,
+	}
+
+	result := generate(t, opts)
+	if want := []string{"f39-cc", "f39-gcc"}; !reflect.DeepEqual(result.Created, want) {
+		t.Errorf("created %v, want %v", result.Created, want)
+	}
+	if want := map[string][]string{"gcc": {"/usr/bin/gcc"}}; !reflect.DeepEqual(result.Shadowed, want) {
+		t.Errorf("shadowed %v, want %v", result.Shadowed, want)
+	}
+
+	prefixPath := filepath.Join(binPath, "f39")
+	data, err := os.ReadFile(filepath.Join(prefixPath, "f39-gcc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "fake-exec fedora /usr/local/bin/gcc") {
+		t.Errorf("shim does not run the first gcc in PATH:\n%s", data)
+	}
+
+	if link, err := os.Readlink(filepath.Join(prefixPath, "f39-cc")); err != nil || link != "f39-gcc" {
+		t.Errorf("f39-cc links to %q (%v), want f39-gcc", link, err)
+	}
+
+	result = generate(t, opts)
+	if len(result.Created)+len(result.Updated)+len(result.Removed) != 0 {
+		t.Errorf("regenerating changed %v %v %v", result.Created, result.Updated, result.Removed)
+	}
+
+	// a kept shim stays as the user left it
+	modified := []byte("#!/bin/sh\necho modified\n")
+	if err := os.WriteFile(filepath.Join(prefixPath, "f39-gcc"), modified, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Executables = []string{"/usr/bin/gcc"}
+	opts.Aliases = nil
+	opts.Keep = map[string]bool{"f39-gcc": true}
+	result = generate(t, opts)
+	if want := []string{"f39-cc"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("removed %v, want %v", result.Removed, want)
+	}
+
+	data, err = os.ReadFile(filepath.Join(prefixPath, "f39-gcc"))
+	if err != nil || string(data) != string(modified) {
+		t.Errorf("kept shim is %q (%v), want %q", data, err, modified)
+	}
+}
+
+// Files exported for the shims stay in the manifest when it is rewritten
+func TestGenerateKeepsExports(t *testing.T) {
+	binPath := t.TempDir()
+	opts := Options{
+		BinPath:     binPath,
+		Prefix:      "f39",
+		Container:   "fedora",
+		Runtime:     fakeRuntime{},
+		Executables: []string{"/usr/bin/gedit"},
+	}
+	generate(t, opts)
+
+	prefixPath := filepath.Join(binPath, "f39")
+	shimManifest, err := manifest.Read(prefixPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shimManifest.Desktop = map[string]manifest.DesktopEntry{
+		"/apps/f39-org.gnome.gedit.desktop": {Source: "/usr/share/applications/org.gnome.gedit.desktop", Shim: "f39-gedit"},
+	}
+	shimManifest.ManPages = map[string]string{"/man/man1/f39-gedit.1": "f39-gedit"}
+	shimManifest.Completions = map[string]string{"/completions/f39-gedit": "f39-gedit"}
+	shimManifest.DBusServices = map[string]string{"/services/org.gnome.gedit.service": "f39-gedit"}
+	if err := shimManifest.Write(prefixPath); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Executables = append(opts.Executables, "/usr/bin/gcc")
+	generate(t, opts)
+
+	regenerated, err := manifest.Read(prefixPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(regenerated.Desktop, shimManifest.Desktop) ||
+		!reflect.DeepEqual(regenerated.ManPages, shimManifest.ManPages) ||
+		!reflect.DeepEqual(regenerated.Completions, shimManifest.Completions) ||
+		!reflect.DeepEqual(regenerated.DBusServices, shimManifest.DBusServices) {
+		t.Errorf("exported files are %+v, want those of %+v", regenerated, shimManifest)
+	}
+}
+
+func TestGenerateNotManaged(t *testing.T) {
+	binPath := t.TempDir()
+	if err := os.Mkdir(filepath.Join(binPath, "f39"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Generate(context.Background(), Options{
+		BinPath:     binPath,
+		Prefix:      "f39",
+		Container:   "fedora",
+		Runtime:     fakeRuntime{},
+		Executables: []string{"/usr/bin/gcc"},
+	})
+	if !errors.Is(err, ErrNotManaged) {
+		t.Errorf("got %v, want ErrNotManaged", err)
+	}
+}
+
+func TestShimName(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{DefaultNameFormat, "f39-gcc"},
+		{"{exe}", "gcc"},
+		{"{exe}.{container}", "gcc.fedora"},
+	}
+
+	for _, test := range tests {
+		if got := ShimName(test.format, "f39", "fedora", "gcc"); got != test.want {
+			t.Errorf("ShimName(%q) = %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestEscapeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"g++-12", "g++-12"},
+		{"python3.11", "python3.11"},
+		{"my tool", "my%20tool"},
+		{"100%", "100%25"},
+		{"$(id)", "%24%28id%29"},
+		{"-dash", "%2Ddash"},
+		{".hidden", "%2Ehidden"},
+		{"prod/db-0", "prod%2Fdb-0"},
+		{"größe", "gr%C3%B6%C3%9Fe"},
+	}
+
+	for _, test := range tests {
+		if got := EscapeName(test.name); got != test.want {
+			t.Errorf("EscapeName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Markers of the prefix directories btb manages. A marker records which
+ * version of btb created the directory for which container. Markers of
+ * older versions are empty files, which are rewritten the next time the
+ * directory is.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package btb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version of btb, set when building with
+// -ldflags "-X btb/pkg/btb.Version=1.2.3"
+var Version = "dev"
+
+// File marking the prefix directories btb manages, which scans and shims
+// skip on PATH. What btb knows about their shims is in their manifest.
+const MarkerName = ".btbMarker"
+
+// Format of the markers written by this version, 0 for empty markers
+const MarkerFormat = 1
+
+var ErrNotManaged = errors.New("not managed by btb (missing " + MarkerName + ")")
+
+type Marker struct {
+	Format     int       `json:"format"`
+	BtbVersion string    `json:"btb_version"`
+	Prefix     string    `json:"prefix"`
+	Container  string    `json:"container"`
+	Runtime    string    `json:"runtime"`
+	Created    time.Time `json:"created"`
+}
+
+// NewMarker returns the marker of a prefix directory created now
+func NewMarker(prefix string, container string, runtime string) *Marker {
+	return &Marker{
+		Format:     MarkerFormat,
+		BtbVersion: Version,
+		Prefix:     prefix,
+		Container:  container,
+		Runtime:    runtime,
+		Created:    time.Now(),
+	}
+}
+
+// ReadMarker reads the marker of dir, which is only the format 0 for an
+// empty one. Fails with ErrNotManaged if dir has none.
+func ReadMarker(dir string) (*Marker, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MarkerName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", dir, ErrNotManaged)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var marker Marker
+	if len(data) == 0 {
+		return &marker, nil
+	}
+
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, MarkerName), err)
+	}
+
+	return &marker, nil
+}
+
+// WriteMarker writes the marker of dir for the container of marker,
+// keeping the creation time of the marker dir had before, if any. The
+// marker is replaced, so the previous prefix directory keeps its own
+// when dir was staged from it.
+func WriteMarker(dir string, marker *Marker, mode os.FileMode) error {
+	if previous, err := ReadMarker(dir); err == nil && !previous.Created.IsZero() {
+		marker.Created = previous.Created
+	}
+
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteShim(filepath.Join(dir, MarkerName), string(data)+"\n", mode&0666)
+}
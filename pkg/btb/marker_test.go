@@ -0,0 +1,48 @@
+package btb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMarker(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ReadMarker(dir); !errors.Is(err, ErrNotManaged) {
+		t.Fatalf("ReadMarker without a marker: got %v, want ErrNotManaged", err)
+	}
+
+	// markers of older versions are empty
+	if err := os.WriteFile(filepath.Join(dir, MarkerName), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	marker, err := ReadMarker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker.Format != 0 {
+		t.Errorf("format of an empty marker: got %d, want 0", marker.Format)
+	}
+
+	created := time.Date(2021, 11, 2, 10, 0, 0, 0, time.UTC)
+	if err := WriteMarker(dir, &Marker{Format: MarkerFormat, Container: "f35", Created: created}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a marker written again keeps when the directory was created
+	if err := WriteMarker(dir, NewMarker("f35", "f35", "toolbox"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	marker, err = ReadMarker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker.Format != MarkerFormat || marker.Runtime != "toolbox" || marker.BtbVersion != Version {
+		t.Errorf("got %+v", marker)
+	}
+	if !marker.Created.Equal(created) {
+		t.Errorf("created: got %s, want %s", marker.Created, created)
+	}
+}
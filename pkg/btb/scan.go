@@ -0,0 +1,502 @@
+/*
+ * Scanning of a container's executables with shell scripts run inside
+ * of it.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package btb
+
+import (
+	"btb/pkg/filter"
+	"btb/pkg/runtime"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// LoginPath takes PATH from a login shell of the user in the container
+// since the inherited one misses what profile scripts add, eg.
+// ~/.cargo/bin. Scripts start with it to see the same PATH.
+const LoginPath = `shell=$(getent passwd "$(id -un)" 2>/dev/null | cut -d: -f7)
+login_path=$("${shell:-${SHELL:-sh}}" -lc 'printf "\n%s" "$PATH"' </dev/null 2>/dev/null | tail -n 1)
+[ -n "$login_path" ] && PATH=$login_path
+`
+
+// ExecutableTest checks that $file is a file the user in the container
+// can execute. test -x asks the kernel via access(2) so group and
+// supplementary group permissions, ACLs, and root are handled the same
+// as when running it.
+const ExecutableTest = `[ -f "$file" ] && [ -x "$file" ]`
+
+// Prints every executable file found in the container's PATH, or the
+// search path given as the first argument, and the directories given as
+// the other arguments, one per line, in PATH order. What it skips is
+// printed as !KIND<tab>PATH lines instead, see Problem, and every
+// directory as !scanned<tab>DIR before its executables.
+const scanScript = LoginPath + `search_path=${1:-$PATH}
+shift
+IFS=:
+dirs=
+seen=
+for dir in $search_path "$@"; do
+	case $dir in "~/"*) dir=$HOME/${dir#"~/"} ;; esac
+	[ -d "$dir" ] || continue
+	[ -e "$dir/.btbMarker" ] && continue
+	if [ ! -r "$dir" ] || [ ! -x "$dir" ]; then
+		printf '!unreadable\t%s\n' "$dir"
+		continue
+	fi
+	# the same directory twice, eg. /bin linking to /usr/bin
+	real=$(cd "$dir" 2>/dev/null && pwd -P) || continue
+	case "$seen:" in *":$real:"*) continue ;; esac
+	seen="$seen:$real"
+	dirs="$dirs:$dir"
+done
+[ -n "$dirs" ] || exit 0
+set -- ${dirs#:}
+for dir; do
+	printf '!scanned\t%s\n' "$dir"
+	{ find -H "$dir" -mindepth 1 -maxdepth 1 ! -type d -exec sh -c '
+		for file; do
+			if [ -L "$file" ] && [ ! -e "$file" ]; then
+				printf "!dangling\t%s\n" "$file"
+			elif ` + ExecutableTest + `; then
+				echo "$file"
+			fi
+		done
+		exit 0' sh {} + 2>&1 >&3 | while IFS= read -r message; do
+			printf '!error\t%s\n' "$message"
+		done; } 3>&1
+done
+exit 0
+`
+
+// Prints the files owned by the packages given as arguments
+const packageScript = `if command -v rpm >/dev/null 2>&1; then
+	check="rpm -q --quiet"
+	query="rpm -ql"
+elif command -v dpkg >/dev/null 2>&1; then
+	check="dpkg -s"
+	query="dpkg -L"
+else
+	echo "no rpm or dpkg found to query packages with" >&2
+	exit 1
+fi
+for pkg; do
+	$check "$pkg" >/dev/null 2>&1 || { echo "package $pkg is not installed" >&2; exit 1; }
+	$query "$pkg"
+done
+`
+
+// Prints the kind of the files given as arguments that shims may not be
+// able to run, as KIND<tab>FILE lines, see Inspection. ELF files are of
+// another architecture if their machine differs from that of the shell,
+// except for i386 on x86_64. Capabilities are only seen if getcap is
+// installed, whose output is "FILE CAPS" or "FILE = CAPS" for older
+// versions.
+const inspectScript = `elf=$(printf '\177ELF')
+cr=$(printf '\r')
+machine=$(od -An -tx1 -j18 -N2 /bin/sh 2>/dev/null)
+i386=$(printf '\3\0' | od -An -tx1)
+x86_64=$(printf '\76\0' | od -An -tx1)
+for file; do
+	{ [ -u "$file" ] || [ -g "$file" ]; } && printf 'privileged\t%s\n' "$file"
+	line=
+	IFS= read -r line <"$file" 2>/dev/null
+	case $line in
+	"$elf"*)
+		file_machine=$(od -An -tx1 -j18 -N2 "$file" 2>/dev/null)
+		[ -n "$machine" ] && [ -n "$file_machine" ] && [ "$file_machine" != "$machine" ] &&
+			! { [ "$machine" = "$x86_64" ] && [ "$file_machine" = "$i386" ]; } &&
+			printf 'foreign\t%s\n' "$file"
+		;;
+	"#!"*)
+		printf 'script\t%s\n' "$file"
+		shebang=${line#"#!"}
+		shebang=${shebang%"$cr"}
+		shebang=${shebang#"${shebang%%[! 	]*}"}
+		interpreter=${shebang%%[ 	]*}
+		argument=${shebang#"$interpreter"}
+		argument=${argument#"${argument%%[! 	]*}"}
+		argument=${argument%%[ 	]*}
+		if [ ! -x "$interpreter" ]; then
+			printf 'interpreter\t%s\n' "$file"
+		elif [ "${interpreter##*/}" = env ] && [ -n "$argument" ] && [ "${argument#-}" = "$argument" ] &&
+			! command -v "$argument" >/dev/null 2>&1; then
+			printf 'interpreter\t%s\n' "$file"
+		fi
+		;;
+	esac
+done
+if command -v getcap >/dev/null 2>&1; then
+	getcap "$@" 2>/dev/null | sed 's/ \(= \)\{0,1\}[^ ]*$//' | while IFS= read -r file; do
+		printf 'privileged\t%s\n' "$file"
+	done
+fi
+exit 0
+`
+
+// Prints every directory read from stdin with its path with symlinks
+// resolved
+const realDirScript = `while read -r dir; do
+	printf '%s\t%s\n' "$dir" "$(cd "$dir" 2>/dev/null && pwd -P)"
+done
+exit 0
+`
+
+// ScriptCommand returns the argument list that runs script with shell,
+// or sh if shell is empty. The scripts of btb only need a POSIX shell,
+// eg. sh, dash, bash, or ksh, but not zsh, which does not split words.
+func ScriptCommand(shell string, script string, scriptArgs ...string) []string {
+	if shell == "" {
+		shell = "sh"
+	}
+
+	return append([]string{shell, "-c", script, "sh"}, scriptArgs...)
+}
+
+// RunScript runs a shell script inside of container with shell, see
+// ScriptCommand, and returns its output. With a nil rt it runs where btb
+// is running, ie. when already in the container. Failures are a
+// *CommandError, returned along with what the script printed, or, once
+// ctx is done, wrap its error.
+func RunScript(ctx context.Context, rt runtime.Runtime, container string, shell string, script string,
+	stdin io.Reader, scriptArgs ...string) ([]byte, error) {
+	command := ScriptCommand(shell, script, scriptArgs...)
+	if rt != nil {
+		command = rt.Command(container, command...)
+	}
+
+	return runCommand(ctx, command, stdin, nil)
+}
+
+// SearchPath returns the directories rt has the commands of container in
+// if it is a runtime.Searcher, nil for the PATH of the container
+func SearchPath(ctx context.Context, rt runtime.Runtime, container string) ([]string, error) {
+	searcher, ok := rt.(runtime.Searcher)
+	if !ok {
+		return nil, nil
+	}
+
+	output, err := runCommand(ctx, searcher.SearchCommand(container), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line != "" {
+			dirs = append(dirs, line)
+		}
+	}
+
+	return dirs, nil
+}
+
+// Runs command where btb is running, see RunScript. Calls onLine, if not
+// nil, with every line of the output as soon as it is printed.
+func runCommand(ctx context.Context, command []string, stdin io.Reader, onLine func(line string)) ([]byte, error) {
+	var stderr bytes.Buffer
+	stdout := &lineWriter{onLine: onLine}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	output := stdout.output.Bytes()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%s: %w", command[0], ctx.Err())
+	} else if err != nil {
+		return output, &CommandError{Command: command, Stderr: stderr.String(), Err: err}
+	}
+
+	return output, nil
+}
+
+// Collects the output of a command, passing every full line to onLine
+type lineWriter struct {
+	output bytes.Buffer
+	// Start of the line onLine did not get yet
+	start  int
+	onLine func(line string)
+}
+
+func (writer *lineWriter) Write(data []byte) (int, error) {
+	writer.output.Write(data)
+	if writer.onLine == nil {
+		return len(data), nil
+	}
+
+	for {
+		rest := writer.output.Bytes()[writer.start:]
+		end := bytes.IndexByte(rest, '\n')
+		if end < 0 {
+			return len(data), nil
+		}
+
+		writer.onLine(string(rest[:end]))
+		writer.start += end + 1
+	}
+}
+
+// Runs a script with the container and timeout of opts, calling onLine
+// with the lines it prints if it is not nil
+func runScript(ctx context.Context, opts *Options, script string, stdin io.Reader, onLine func(line string),
+	scriptArgs ...string) ([]string, error) {
+	if opts.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	rt := opts.Runtime
+	if opts.InContainer {
+		rt = nil
+	}
+
+	command := ScriptCommand(opts.Shell, script, scriptArgs...)
+	if rt != nil {
+		command = rt.Command(opts.Container, command...)
+	}
+
+	output, err := runCommand(ctx, command, stdin, onLine)
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) && opts.OnError != nil {
+		err = opts.OnError(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines, nil
+}
+
+// Scan returns the paths of the executables in the container in PATH
+// order, only those owned by opts.Packages if any are given
+func Scan(ctx context.Context, opts Options) ([]string, error) {
+	searchCtx := ctx
+	if opts.Timeout != 0 {
+		var cancel context.CancelFunc
+		searchCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	searchPath, err := SearchPath(searchCtx, opts.Runtime, opts.Container)
+	if err != nil {
+		return nil, err
+	}
+
+	var onLine func(line string)
+	if opts.OnProgress != nil {
+		var progress Progress
+		onLine = func(line string) {
+			if strings.HasPrefix(line, "!scanned\t") {
+				progress.Dirs++
+			} else if !strings.HasPrefix(line, "!") {
+				progress.Executables++
+			} else {
+				return
+			}
+			opts.OnProgress(progress)
+		}
+	}
+
+	scanArgs := append([]string{strings.Join(searchPath, ":")}, opts.ScanDirs...)
+	lines, err := runScript(ctx, &opts, scanScript, nil, onLine, scanArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	var allExe []string
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "!") {
+			allExe = append(allExe, line)
+		} else if fields := strings.SplitN(line[1:], "\t", 2); len(fields) == 2 && fields[0] != "scanned" &&
+			opts.OnProblem != nil {
+			opts.OnProblem(Problem{Kind: fields[0], Path: fields[1]})
+		}
+	}
+	if len(opts.Packages) == 0 {
+		return allExe, nil
+	}
+
+	files, err := runScript(ctx, &opts, packageScript, nil, nil, opts.Packages...)
+	if err != nil {
+		return nil, err
+	}
+
+	// packages list /bin/ls on usr-merged systems where the scan, which
+	// skips /bin as /usr/bin, found /usr/bin/ls
+	realDirs, err := realDirs(ctx, &opts, append(append([]string{}, allExe...), files...))
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]bool, len(files))
+	for _, file := range files {
+		owned[realPath(realDirs, file)] = true
+	}
+
+	var packageExe []string
+	for _, exePath := range allExe {
+		if owned[realPath(realDirs, exePath)] {
+			packageExe = append(packageExe, exePath)
+		}
+	}
+
+	return packageExe, nil
+}
+
+// Executables of the container that shims cannot run as they are
+type Inspection struct {
+	// Setuid, setgid, or capability bearing, which run without their
+	// privileges through the runtime, eg. ping or newuidmap
+	Privileged []string `json:"privileged"`
+	// ELF files of another architecture, eg. of a cross toolchain
+	Foreign []string `json:"foreign"`
+	// Scripts whose interpreter is not installed
+	NoInterpreter []string `json:"no_interpreter"`
+	// Every script, ie. file starting with #!
+	Scripts []string `json:"scripts"`
+}
+
+// Inspect looks at the executables at paths in the container for those
+// that shims cannot run as they are
+func Inspect(ctx context.Context, opts Options, paths []string) (*Inspection, error) {
+	inspection := &Inspection{}
+	if len(paths) == 0 {
+		return inspection, nil
+	}
+
+	lines, err := runScript(ctx, &opts, inspectScript, nil, nil, paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "privileged":
+			inspection.Privileged = append(inspection.Privileged, fields[1])
+		case "foreign":
+			inspection.Foreign = append(inspection.Foreign, fields[1])
+		case "interpreter":
+			inspection.NoInterpreter = append(inspection.NoInterpreter, fields[1])
+		case "script":
+			inspection.Scripts = append(inspection.Scripts, fields[1])
+		}
+	}
+
+	return inspection, nil
+}
+
+// What a run got done so far, see Options.OnProgress
+type Progress struct {
+	// Directories and executables the scan went through
+	Dirs        int
+	Executables int
+	// Shims Generate wrote
+	Shims int
+}
+
+// Entry of the search path a scan skipped instead of failing
+type Problem struct {
+	// dangling for a symlink to nothing, unreadable for a directory, or
+	// error for what find complained about
+	Kind string `json:"kind"`
+	// Path of the entry, or the message of find for errors
+	Path string `json:"path"`
+}
+
+// Returns the directories of paths with symlinks resolved in the
+// container keyed by directory
+func realDirs(ctx context.Context, opts *Options, paths []string) (map[string]string, error) {
+	seen := make(map[string]bool)
+	var input strings.Builder
+	for _, path := range paths {
+		if dir := filepath.Dir(path); !seen[dir] {
+			seen[dir] = true
+			input.WriteString(dir + "\n")
+		}
+	}
+
+	lines, err := runScript(ctx, opts, realDirScript, strings.NewReader(input.String()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make(map[string]string, len(lines))
+	for _, line := range lines {
+		if fields := strings.SplitN(line, "\t", 2); len(fields) == 2 && fields[1] != "" {
+			dirs[fields[0]] = fields[1]
+		}
+	}
+
+	return dirs, nil
+}
+
+// Returns path in the real directory of realDirs, or as is if it has none
+func realPath(realDirs map[string]string, path string) string {
+	if dir, ok := realDirs[filepath.Dir(path)]; ok {
+		return filepath.Join(dir, filepath.Base(path))
+	}
+
+	return path
+}
+
+// Resolve picks the executable for every name like the shell would, ie.
+// the first one in allExe, which is in PATH order, among those the
+// filters match, see filter.Filter.Deny for deny. Also returns the paths
+// shadowed by the one picked keyed by name.
+func Resolve(allExe []string, include []string, exclude []string, deny []string) (map[string]string,
+	map[string][]string, error) {
+	exeFilter, err := filter.New(include, exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := exeFilter.Deny(deny); err != nil {
+		return nil, nil, err
+	}
+
+	exeMap := make(map[string]string)
+	shadowed := make(map[string][]string)
+	for _, exePath := range allExe {
+		exe := filepath.Base(exePath)
+		if !exeFilter.Match(exe) {
+			continue
+		}
+
+		if _, ok := exeMap[exe]; ok {
+			shadowed[exe] = append(shadowed[exe], exePath)
+		} else {
+			exeMap[exe] = exePath
+		}
+	}
+
+	return exeMap, shadowed, nil
+}
@@ -0,0 +1,161 @@
+package btb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	allExe := []string{"/home/user/.cargo/bin/rustc", "/usr/bin/rustc", "/usr/bin/cargo", "/usr/bin/gcc",
+		"/usr/local/bin/gcc", "/opt/bin/gcc"}
+
+	exeMap, shadowed, err := Resolve(allExe, []string{"rustc", "gcc"}, nil, DefaultExcludes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantMap := map[string]string{"rustc": "/home/user/.cargo/bin/rustc", "gcc": "/usr/bin/gcc"}
+	if !reflect.DeepEqual(exeMap, wantMap) {
+		t.Errorf("got %v, want %v", exeMap, wantMap)
+	}
+
+	wantShadowed := map[string][]string{
+		"rustc": {"/usr/bin/rustc"},
+		"gcc":   {"/usr/local/bin/gcc", "/opt/bin/gcc"},
+	}
+	if !reflect.DeepEqual(shadowed, wantShadowed) {
+		t.Errorf("got shadowed %v, want %v", shadowed, wantShadowed)
+	}
+
+	if _, _, err := Resolve(allExe, []string{"re:("}, nil, nil); err == nil {
+		t.Error("a bad pattern did not fail")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	header, err := os.ReadFile("/bin/sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header = header[:20]
+	foreignHeader := append([]byte{}, header...)
+	foreignHeader[18] ^= 0x40
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"ls":      string(header),
+		"arm-gcc": string(foreignHeader),
+		"ping":    string(header),
+		"hello":   "#!/bin/sh\necho hello\n",
+		"old":     "#!/nonexistent/python2\n",
+		"tool":    "#!/usr/bin/env btb-nonexistent-interpreter -u\n",
+	}
+	var paths []string
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0755); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	if err := os.Chmod(filepath.Join(dir, "ping"), 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+
+	inspection, err := Inspect(context.Background(), Options{InContainer: true}, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range [][]string{inspection.Privileged, inspection.Foreign, inspection.NoInterpreter,
+		inspection.Scripts} {
+		for i := range kind {
+			kind[i] = filepath.Base(kind[i])
+		}
+		sort.Strings(kind)
+	}
+	want := &Inspection{
+		Privileged:    []string{"ping"},
+		Foreign:       []string{"arm-gcc"},
+		NoInterpreter: []string{"old", "tool"},
+		Scripts:       []string{"hello", "old", "tool"},
+	}
+	if !reflect.DeepEqual(inspection, want) {
+		t.Errorf("Inspect = %+v, want %+v", inspection, want)
+	}
+}
+
+func TestScanProblems(t *testing.T) {
+	dir, unreadable := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tool"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "gone"), filepath.Join(dir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(unreadable, 0700)
+
+	var problems []Problem
+	allExe, err := Scan(context.Background(), Options{
+		InContainer: true,
+		ScanDirs:    []string{dir, unreadable},
+		OnProblem:   func(problem Problem) { problems = append(problems, problem) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, exePath := range allExe {
+		found = found || exePath == filepath.Join(dir, "tool")
+	}
+	if !found {
+		t.Errorf("Scan did not find %s", filepath.Join(dir, "tool"))
+	}
+
+	want := []Problem{{"dangling", filepath.Join(dir, "dangling")}}
+	if os.Geteuid() != 0 {
+		want = append([]Problem{{"unreadable", unreadable}}, want...)
+	}
+	var got []Problem
+	for _, problem := range problems {
+		if strings.HasPrefix(problem.Path, dir) || problem.Path == unreadable {
+			got = append(got, problem)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan skipped %+v, want %+v", got, want)
+	}
+}
+
+func TestScanProgress(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"one", "two"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var last Progress
+	allExe, err := Scan(context.Background(), Options{
+		InContainer: true,
+		ScanDirs:    []string{dir},
+		OnProgress:  func(progress Progress) { last = progress },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if last.Dirs == 0 || last.Executables != len(allExe) {
+		t.Errorf("last progress is %+v, want every directory and %d executables", last, len(allExe))
+	}
+}
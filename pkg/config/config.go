@@ -0,0 +1,348 @@
+/*
+ * Configuration file holding defaults for the command line flags.
+ *
+ * Eg. ~/.config/btb/config.yaml
+ *   binpath: /home/user/.local/bin
+ *   prefix: f35
+ *   container: fedora-toolbox-35
+ *   runtime: toolbox
+ *   include: [cargo*, rustc]
+ *   exclude: [re:^rust-.*]
+ *   never_export: [htop, re:^python3?$]
+ *   packages: [gcc, clang]
+ *   alias_links: true
+ *   scan_dirs: [/opt/foo/bin, ~/.local/share/pnpm]
+ *   template: /home/user/.config/btb/shim.tmpl
+ *   shim_mode: script
+ *   shim_format: sh
+ *   on_modified: backup
+ *   on_conflict: warn
+ *   name_format: "{prefix}-{exe}"
+ *   start_timeout: 30s
+ *   host_fallback: true
+ *   env: [SSH_AUTH_SOCK]
+ *   gui_env: true
+ *   command_env:
+ *     firefox: [MOZ_ENABLE_WAYLAND]
+ *     gradle: [JAVA_HOME=/usr/lib/jvm/java-17]
+ *     git: [SSH_AUTH_SOCK]
+ *   command_args:
+ *     npm: [--prefix, /home/user/.npm-container]
+ *   command_wrapper:
+ *     code: [flatpak-spawn, --host]
+ *   hooks:
+ *     pre_scan:
+ *       - run: dnf -y upgrade --refresh
+ *         in_container: true
+ *     post_generate:
+ *       - rm -f ~/.zcompdump
+ *       - notify-send btb "$BTB_CREATED new commands from $BTB_CONTAINER"
+ *   jobs: 8
+ *   timeout: 2m
+ *   profiles:
+ *     f36:
+ *       prefix: f36
+ *       container: fedora-toolbox-36
+ *
+ * Top level values are the defaults for every profile. Hooks are run by
+ * sh on the host unless in_container is set, see cmd/hooks.go.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type Profile struct {
+	BinPath      string        `yaml:"binpath,omitempty"`
+	Prefix       string        `yaml:"prefix,omitempty"`
+	Container    string        `yaml:"container,omitempty"`
+	Runtime      string        `yaml:"runtime,omitempty"`
+	Include      []string      `yaml:"include,omitempty"`
+	Exclude      []string      `yaml:"exclude,omitempty"`
+	Packages     []string      `yaml:"packages,omitempty"`
+	ScanDirs     []string      `yaml:"scan_dirs,omitempty"`
+	AliasLinks   bool          `yaml:"alias_links,omitempty"`
+	Template     string        `yaml:"template,omitempty"`
+	ShimMode     string        `yaml:"shim_mode,omitempty"`
+	ShimFormat   string        `yaml:"shim_format,omitempty"`
+	OnModified   string        `yaml:"on_modified,omitempty"`
+	OnConflict   string        `yaml:"on_conflict,omitempty"`
+	NameFormat   string        `yaml:"name_format,omitempty"`
+	StartTimeout time.Duration `yaml:"start_timeout,omitempty"`
+	KeepAlive    time.Duration `yaml:"keep_alive,omitempty"`
+	HostFallback bool          `yaml:"host_fallback,omitempty"`
+	Env          []string      `yaml:"env,omitempty"`
+	GUIEnv       bool          `yaml:"gui_env,omitempty"`
+	Jobs         int           `yaml:"jobs,omitempty"`
+	Timeout      time.Duration `yaml:"timeout,omitempty"`
+	// POSIX shell the scripts of btb run with in the container
+	Shell string `yaml:"shell,omitempty"`
+	// Translate the host paths given to shims, HOST=CONTAINER prefixes
+	// of PathMap first
+	TranslatePaths bool     `yaml:"translate_paths,omitempty"`
+	PathMap        []string `yaml:"path_map,omitempty"`
+	// What shims do outside of the home directory, see --on-unshared-cwd
+	OnUnsharedCwd string `yaml:"on_unshared_cwd,omitempty"`
+	// Export setuid, setgid, and capability bearing executables too
+	IncludePrivileged bool `yaml:"include_privileged,omitempty"`
+	// Export compiled executables only
+	SkipScripts bool `yaml:"skip_scripts,omitempty"`
+	// Install the shims for every user, see --system
+	System bool `yaml:"system,omitempty"`
+	// Run the runtime with sudo or pkexec for rootful containers, see --root
+	Root string `yaml:"root,omitempty"`
+	// Export the executables of the distribution or the others only
+	OnlySystemPaths bool `yaml:"only_system_paths,omitempty"`
+	OnlyUserPaths   bool `yaml:"only_user_paths,omitempty"`
+	// Names never exported unless an include pattern matches them, added
+	// to those of the top level and the defaults
+	NeverExport       []string `yaml:"never_export,omitempty"`
+	NoDefaultExcludes bool     `yaml:"no_default_excludes,omitempty"`
+	// Leave out the executables named like a command of the host
+	SkipHostDuplicates bool `yaml:"skip_host_duplicates,omitempty"`
+	// Variables for single commands keyed by executable name, NAME=value
+	// to set one or NAME to pass it along like env
+	CommandEnv map[string][]string `yaml:"command_env,omitempty"`
+	// Arguments put before those given to single commands
+	CommandArgs map[string][]string `yaml:"command_args,omitempty"`
+	// Commands that single commands are run through in the container
+	CommandWrapper map[string][]string `yaml:"command_wrapper,omitempty"`
+	Hooks          Hooks               `yaml:"hooks,omitempty"`
+}
+
+type Hooks struct {
+	// Run before the container is scanned
+	PreScan []Hook `yaml:"pre_scan,omitempty"`
+	// Run after the shims were generated
+	PostGenerate []Hook `yaml:"post_generate,omitempty"`
+}
+
+type Hook struct {
+	Run         string `yaml:"run"`
+	InContainer bool   `yaml:"in_container,omitempty"`
+}
+
+// UnmarshalYAML also takes a hook run on the host as just its command
+func (hook *Hook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&hook.Run); err == nil {
+		return nil
+	}
+
+	type plain Hook
+	return unmarshal((*plain)(hook))
+}
+
+type Config struct {
+	Profile  `yaml:",inline"`
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+func DefaultPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "btb", "config.yaml"), nil
+}
+
+// Load reads the config at path. An empty path loads the default
+// config which, unlike an explicit path, is allowed to not exist.
+func Load(path string) (*Config, error) {
+	var config Config
+
+	if path == "" {
+		defaultPath, err := DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(defaultPath); errors.Is(err, os.ErrNotExist) {
+			return &config, nil
+		}
+		path = defaultPath
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &config, nil
+}
+
+// Write writes the config to path, leaving out values that are not set
+func (config *Config) Write(path string) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func (config *Config) ProfileNames() []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Resolve returns the named profile with any unset values taken from
+// the top level defaults. An empty name returns just the defaults.
+func (config *Config) Resolve(name string) (Profile, error) {
+	resolved := config.Profile
+	if name == "" {
+		return resolved, nil
+	}
+
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %q", name)
+	}
+
+	if profile.BinPath != "" {
+		resolved.BinPath = profile.BinPath
+	}
+	if profile.Prefix != "" {
+		resolved.Prefix = profile.Prefix
+	}
+	if profile.Container != "" {
+		resolved.Container = profile.Container
+	}
+	if profile.Runtime != "" {
+		resolved.Runtime = profile.Runtime
+	}
+	if len(profile.Include) != 0 {
+		resolved.Include = profile.Include
+	}
+	if len(profile.Exclude) != 0 {
+		resolved.Exclude = profile.Exclude
+	}
+	if len(profile.Packages) != 0 {
+		resolved.Packages = profile.Packages
+	}
+	if len(profile.ScanDirs) != 0 {
+		resolved.ScanDirs = profile.ScanDirs
+	}
+	if profile.AliasLinks {
+		resolved.AliasLinks = true
+	}
+	if profile.Template != "" {
+		resolved.Template = profile.Template
+	}
+	if profile.ShimMode != "" {
+		resolved.ShimMode = profile.ShimMode
+	}
+	if profile.ShimFormat != "" {
+		resolved.ShimFormat = profile.ShimFormat
+	}
+	if profile.Shell != "" {
+		resolved.Shell = profile.Shell
+	}
+	if profile.OnModified != "" {
+		resolved.OnModified = profile.OnModified
+	}
+	if profile.OnConflict != "" {
+		resolved.OnConflict = profile.OnConflict
+	}
+	if profile.NameFormat != "" {
+		resolved.NameFormat = profile.NameFormat
+	}
+	if profile.StartTimeout != 0 {
+		resolved.StartTimeout = profile.StartTimeout
+	}
+	if profile.KeepAlive != 0 {
+		resolved.KeepAlive = profile.KeepAlive
+	}
+	if profile.HostFallback {
+		resolved.HostFallback = true
+	}
+	if profile.OnUnsharedCwd != "" {
+		resolved.OnUnsharedCwd = profile.OnUnsharedCwd
+	}
+	if profile.IncludePrivileged {
+		resolved.IncludePrivileged = true
+	}
+	if profile.SkipScripts {
+		resolved.SkipScripts = true
+	}
+	if profile.System {
+		resolved.System = true
+	}
+	if profile.Root != "" {
+		resolved.Root = profile.Root
+	}
+	if profile.OnlySystemPaths {
+		resolved.OnlySystemPaths = true
+	}
+	if profile.OnlyUserPaths {
+		resolved.OnlyUserPaths = true
+	}
+	if len(profile.NeverExport) != 0 {
+		resolved.NeverExport = append(append([]string{}, resolved.NeverExport...), profile.NeverExport...)
+	}
+	if profile.NoDefaultExcludes {
+		resolved.NoDefaultExcludes = true
+	}
+	if profile.SkipHostDuplicates {
+		resolved.SkipHostDuplicates = true
+	}
+	if profile.TranslatePaths {
+		resolved.TranslatePaths = true
+	}
+	if len(profile.PathMap) != 0 {
+		resolved.PathMap = profile.PathMap
+	}
+	if len(profile.Env) != 0 {
+		resolved.Env = profile.Env
+	}
+	if profile.GUIEnv {
+		resolved.GUIEnv = true
+	}
+	if len(profile.CommandEnv) != 0 {
+		resolved.CommandEnv = profile.CommandEnv
+	}
+	if len(profile.CommandArgs) != 0 {
+		resolved.CommandArgs = profile.CommandArgs
+	}
+	if len(profile.CommandWrapper) != 0 {
+		resolved.CommandWrapper = profile.CommandWrapper
+	}
+	if len(profile.Hooks.PreScan) != 0 {
+		resolved.Hooks.PreScan = profile.Hooks.PreScan
+	}
+	if len(profile.Hooks.PostGenerate) != 0 {
+		resolved.Hooks.PostGenerate = profile.Hooks.PostGenerate
+	}
+	if profile.Jobs != 0 {
+		resolved.Jobs = profile.Jobs
+	}
+	if profile.Timeout != 0 {
+		resolved.Timeout = profile.Timeout
+	}
+
+	return resolved, nil
+}
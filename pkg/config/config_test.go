@@ -0,0 +1,61 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolve(t *testing.T) {
+	config := Config{
+		Profile: Profile{
+			BinPath:   "/home/user/.local/bin",
+			Prefix:    "f35",
+			Container: "fedora-toolbox-35",
+			Include:   []string{"cargo*"},
+			Timeout:   time.Minute,
+			Hooks:     Hooks{PreScan: []Hook{{Run: "dnf -y upgrade", InContainer: true}}},
+		},
+		Profiles: map[string]Profile{
+			"f36": {
+				Prefix:    "f36",
+				Container: "fedora-toolbox-36",
+				Runtime:   "podman",
+				Hooks:     Hooks{PostGenerate: []Hook{{Run: "rm -f ~/.zcompdump"}}},
+			},
+		},
+	}
+
+	defaults, err := config.Resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(defaults, config.Profile) {
+		t.Errorf("defaults are %+v, want %+v", defaults, config.Profile)
+	}
+
+	resolved, err := config.Resolve("f36")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := config.Profile
+	want.Prefix = "f36"
+	want.Container = "fedora-toolbox-36"
+	want.Runtime = "podman"
+	want.Hooks.PostGenerate = []Hook{{Run: "rm -f ~/.zcompdump"}}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Errorf("resolved to %+v, want %+v", resolved, want)
+	}
+
+	if _, err := config.Resolve("f37"); err == nil {
+		t.Error("an unknown profile did not fail")
+	}
+}
+
+func TestProfileNames(t *testing.T) {
+	config := Config{Profiles: map[string]Profile{"f36": {}, "arch": {}, "f35": {}}}
+	if got, want := config.ProfileNames(), []string{"arch", "f35", "f36"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Binary dispatcher. Instead of one script per shim, a copy of btb is
+ * installed into the prefix directory and every shim is a symlink to it.
+ * When run through a symlink btb looks up the name it was run as in the
+ * dispatch manifest and execs the matching command.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const BinaryName = ".btb-dispatch"
+
+const ManifestName = ".btbDispatch.json"
+
+type Entry struct {
+	Container string   `json:"container"`
+	Runtime   string   `json:"runtime"`
+	Target    string   `json:"target"`
+	Command   []string `json:"command"`
+	// Set for runtimes that do not start stopped containers themselves
+	Running      []string `json:"running,omitempty"`
+	Start        []string `json:"start,omitempty"`
+	StartTimeout int      `json:"start_timeout,omitempty"` // seconds
+	// Set to run Fallback from the host when the container does not exist
+	Exists   []string `json:"exists,omitempty"`
+	Fallback string   `json:"fallback,omitempty"`
+	// Set to home or error for containers that only have the home
+	// directory of the host, see shim.CwdScript
+	OnUnsharedCwd string `json:"on_unshared_cwd,omitempty"`
+}
+
+// Entries keyed by shim name
+type Manifest map[string]Entry
+
+// ReadManifest reads the manifest of dir. A missing manifest is empty.
+func ReadManifest(dir string) (Manifest, error) {
+	manifest := make(Manifest)
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func WriteManifest(dir string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempPath := filepath.Join(dir, ManifestName+".tmp")
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, filepath.Join(dir, ManifestName))
+}
+
+// Invoked reports if btb was started as a dispatcher
+func Invoked() bool {
+	exe, err := os.Executable()
+	return err == nil && filepath.Base(exe) == BinaryName
+}
+
+// Run execs the command for the name btb was started as. Only returns
+// if that fails.
+func Run() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := ReadManifest(filepath.Dir(exe))
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(os.Args[0])
+	entry, ok := manifest[name]
+	if !ok || len(entry.Command) == 0 {
+		return fmt.Errorf("no command for %s in %s", name, filepath.Join(filepath.Dir(exe), ManifestName))
+	}
+
+	if len(entry.Exists) != 0 {
+		if err := exec.Command(entry.Exists[0], entry.Exists[1:]...).Run(); err != nil {
+			return runOnHost(entry.Fallback)
+		}
+	}
+
+	if len(entry.Start) != 0 {
+		if err := startContainer(entry); err != nil {
+			if entry.Fallback != "" {
+				fmt.Fprintf(os.Stderr, "btb: %s\n", err)
+				return runOnHost(entry.Fallback)
+			}
+			return err
+		}
+	}
+
+	if entry.OnUnsharedCwd != "" {
+		if err := checkCwd(entry.OnUnsharedCwd); err != nil {
+			return err
+		}
+	}
+
+	path, err := exec.LookPath(entry.Command[0])
+	if err != nil {
+		return err
+	}
+
+	argv := append(append([]string{}, entry.Command...), os.Args[1:]...)
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+// Changes to the home directory unless the working directory is in it, or
+// fails for the policy error
+func checkCwd(policy string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil || cwd == home || strings.HasPrefix(cwd, home+string(filepath.Separator)) {
+		return nil
+	}
+
+	if policy == "error" {
+		return fmt.Errorf("%s is not in the container, cd to a directory in %s", cwd, home)
+	}
+
+	fmt.Fprintf(os.Stderr, "btb: %s is not in the container, running in %s\n", cwd, home)
+	return os.Chdir(home)
+}
+
+// Starts the container of entry unless it is running
+func startContainer(entry Entry) error {
+	output, err := exec.Command(entry.Running[0], entry.Running[1:]...).Output()
+	if err == nil && strings.TrimSpace(string(output)) == "true" {
+		return nil
+	}
+
+	timeout := time.Duration(entry.StartTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := exec.CommandContext(ctx, entry.Start[0], entry.Start[1:]...)
+	start.Stderr = os.Stderr
+	if err := start.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s did not start within %s", entry.Container, timeout)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Execs exe from the host PATH, skipping the directories btb manages.
+// Only returns if that fails.
+func runOnHost(exe string) error {
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if _, err := os.Stat(filepath.Join(dir, ".btbMarker")); err == nil {
+			continue
+		}
+
+		path := filepath.Join(dir, exe)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			argv := append([]string{path}, os.Args[1:]...)
+			return syscall.Exec(path, argv, os.Environ())
+		}
+	}
+
+	return fmt.Errorf("the container is not available and there is no %s on the host", exe)
+}
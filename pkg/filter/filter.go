@@ -0,0 +1,102 @@
+/*
+ * Include and exclude filters for executable names.
+ *
+ * Patterns are shell globs (eg. cargo*) unless prefixed with re:
+ * in which case they are regular expressions (eg. re:^gcc(-[0-9]+)?$).
+ * Deny patterns exclude the names no include pattern matches, eg. the
+ * commands never worth exporting unless asked for by name.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package filter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type matcher func(name string) bool
+
+type Filter struct {
+	include []matcher
+	exclude []matcher
+	deny    []matcher
+}
+
+func New(include []string, exclude []string) (*Filter, error) {
+	var filter Filter
+	var err error
+
+	if filter.include, err = compile(include); err != nil {
+		return nil, err
+	}
+	if filter.exclude, err = compile(exclude); err != nil {
+		return nil, err
+	}
+
+	return &filter, nil
+}
+
+// Deny adds patterns excluding the names that no include pattern matches
+func (filter *Filter) Deny(patterns []string) error {
+	deny, err := compile(patterns)
+	if err != nil {
+		return err
+	}
+
+	filter.deny = append(filter.deny, deny...)
+	return nil
+}
+
+func compile(patterns []string) ([]matcher, error) {
+	var matchers []matcher
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "re:") {
+			re, err := regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+			}
+			matchers = append(matchers, re.MatchString)
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+		glob := pattern
+		matchers = append(matchers, func(name string) bool {
+			matched, _ := path.Match(glob, name)
+			return matched
+		})
+	}
+
+	return matchers, nil
+}
+
+func matchAny(matchers []matcher, name string) bool {
+	for _, match := range matchers {
+		if match(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match reports if name is included and not excluded. With no include
+// patterns every name is included, unless a deny pattern matches it.
+func (filter *Filter) Match(name string) bool {
+	included := matchAny(filter.include, name)
+	if len(filter.include) != 0 && !included {
+		return false
+	}
+	if !included && matchAny(filter.deny, name) {
+		return false
+	}
+
+	return !matchAny(filter.exclude, name)
+}
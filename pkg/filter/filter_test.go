@@ -0,0 +1,73 @@
+package filter
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		include []string
+		exclude []string
+		name    string
+		want    bool
+	}{
+		{nil, nil, "gcc", true},
+		{[]string{"cargo*"}, nil, "cargo-fmt", true},
+		{[]string{"cargo*"}, nil, "rustc", false},
+		{[]string{"cargo*", "rustc"}, nil, "rustc", true},
+		{nil, []string{"rust-*"}, "rust-gdb", false},
+		{nil, []string{"rust-*"}, "rustc", true},
+		{[]string{"re:^gcc(-[0-9]+)?$"}, nil, "gcc-13", true},
+		{[]string{"re:^gcc(-[0-9]+)?$"}, nil, "gcc-ar", false},
+		{[]string{"g*"}, []string{"re:^gcc-"}, "gcc-13", false},
+		{[]string{"g*"}, []string{"re:^gcc-"}, "gdb", true},
+	}
+
+	for _, test := range tests {
+		filter, err := New(test.include, test.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := filter.Match(test.name); got != test.want {
+			t.Errorf("include %q exclude %q: Match(%q) = %t, want %t",
+				test.include, test.exclude, test.name, got, test.want)
+		}
+	}
+}
+
+func TestDeny(t *testing.T) {
+	tests := []struct {
+		include []string
+		name    string
+		want    bool
+	}{
+		{nil, "ls", false},
+		{nil, "rg", true},
+		{[]string{"ls"}, "ls", true},
+		{[]string{"r*"}, "ls", false},
+	}
+
+	for _, test := range tests {
+		filter, err := New(test.include, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := filter.Deny([]string{"ls", "sh"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := filter.Match(test.name); got != test.want {
+			t.Errorf("include %q: Match(%q) = %t, want %t", test.include, test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewBadPattern(t *testing.T) {
+	for _, pattern := range []string{"[", "re:("} {
+		if _, err := New([]string{pattern}, nil); err == nil {
+			t.Errorf("New(%q) did not fail", pattern)
+		}
+		if _, err := New(nil, []string{pattern}); err == nil {
+			t.Errorf("New with exclude %q did not fail", pattern)
+		}
+	}
+}
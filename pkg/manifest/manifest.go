@@ -0,0 +1,270 @@
+/*
+ * Manifest of the shims btb generated into a prefix directory.
+ *
+ * Records what every shim runs along with a hash of what was written
+ * so btb does not have to guess from file names and contents. Manifests
+ * are kept in $XDG_STATE_HOME/btb keyed by the path of the prefix
+ * directory, which only holds the shims and the .btbMarker that shims and
+ * container scans skip btb's directories on PATH by. Manifests written
+ * into the prefix directory by older versions are read and moved there.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name of the manifest in the prefix directories of older versions
+const LegacyFileName = ".btbManifest.json"
+
+type Shim struct {
+	Container string `json:"container"`
+	Runtime   string `json:"runtime"`
+	Target    string `json:"target"`
+	// Hash of the script contents or, for symlinks, the link target
+	Hash      string    `json:"hash"`
+	Generated time.Time `json:"generated"`
+	// Shim this one links to when it is an alias, see --alias-links
+	Alias string `json:"alias,omitempty"`
+}
+
+// Desktop entry exported to the host for one of the shims
+type DesktopEntry struct {
+	Source string `json:"source"` // path in the container
+	Shim   string `json:"shim"`
+	// Paths of the icons exported with it
+	Icons []string `json:"icons,omitempty"`
+	// MIME types it is the default application for, see desktop
+	// --mime-defaults
+	MimeTypes []string `json:"mime_types,omitempty"`
+}
+
+type Manifest struct {
+	// Path of the prefix directory
+	Dir      string          `json:"dir,omitempty"`
+	Prefix   string          `json:"prefix"`
+	ShimMode string          `json:"shim_mode"`
+	Shims    map[string]Shim `json:"shims"`
+	// Keyed by the path of the exported file
+	Desktop map[string]DesktopEntry `json:"desktop,omitempty"`
+	// Shim each exported man page is for keyed by its path
+	ManPages map[string]string `json:"man_pages,omitempty"`
+	// Shim each exported shell completion is for keyed by its path
+	Completions map[string]string `json:"completions,omitempty"`
+	// Shim each exported D-Bus service is for keyed by its path
+	DBusServices map[string]string `json:"dbus_services,omitempty"`
+}
+
+func New(prefix string, shimMode string) *Manifest {
+	return &Manifest{
+		Prefix:   prefix,
+		ShimMode: shimMode,
+		Shims:    make(map[string]Shim),
+	}
+}
+
+// State directory of the shims installed for every user, see SetSystem
+const SystemStateDir = "/var/lib/btb"
+
+var system bool
+
+// SetSystem makes StateDir return SystemStateDir, for the prefix
+// directories of system-wide installs
+func SetSystem(enabled bool) {
+	system = enabled
+}
+
+// StateDir returns $XDG_STATE_HOME/btb or its default, or SystemStateDir
+// after SetSystem
+func StateDir() (string, error) {
+	if system {
+		return SystemStateDir, nil
+	}
+
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "btb"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".local", "state", "btb"), nil
+}
+
+// Key returns the file name of what btb keeps about dir, the same for
+// every path of it as long as its parent exists
+func Key(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	parent, name := filepath.Split(dir)
+	if realParent, err := filepath.EvalSymlinks(parent); err == nil {
+		dir = filepath.Join(realParent, name)
+	}
+
+	return url.PathEscape(dir), nil
+}
+
+// Path returns the path of the manifest of dir
+func Path(dir string) (string, error) {
+	stateDir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := Key(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(stateDir, "manifests", key+".json"), nil
+}
+
+// Read reads the manifest of dir. Returns an error satisfying
+// errors.Is(err, os.ErrNotExist) if dir has no manifest.
+func Read(dir string) (*Manifest, error) {
+	manifestPath, err := Path(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(manifestPath)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = os.ReadFile(filepath.Join(dir, LegacyFileName))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Shims == nil {
+		manifest.Shims = make(map[string]Shim)
+	}
+
+	return &manifest, nil
+}
+
+// Exists reports if dir has a manifest
+func Exists(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, LegacyFileName)); !errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+
+	manifestPath, err := Path(dir)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(manifestPath)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// Write writes the manifest of dir, moving it out of dir if an older
+// version wrote it there
+func (manifest *Manifest) Write(dir string) error {
+	manifestPath, err := Path(dir)
+	if err != nil {
+		return err
+	}
+
+	if manifest.Dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+		return err
+	}
+
+	tempPath := manifestPath + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempPath, manifestPath); err != nil {
+		return err
+	}
+
+	return removeFile(filepath.Join(dir, LegacyFileName))
+}
+
+// Rename moves the manifest of the directory from to the one of to, as
+// when the directory was renamed
+func Rename(from string, to string) error {
+	fromPath, err := Path(from)
+	if err != nil {
+		return err
+	}
+
+	toPath, err := Path(to)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(fromPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return removeFile(toPath)
+	} else if err != nil {
+		return err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+
+	if err := manifest.Write(to); err != nil {
+		return err
+	}
+
+	return removeFile(fromPath)
+}
+
+// Remove removes the manifest of dir
+func Remove(dir string) error {
+	manifestPath, err := Path(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := removeFile(manifestPath); err != nil {
+		return err
+	}
+
+	return removeFile(filepath.Join(dir, LegacyFileName))
+}
+
+func removeFile(filePath string) error {
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func Hash(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
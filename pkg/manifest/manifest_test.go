@@ -0,0 +1,62 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestState(t *testing.T) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	defer os.Setenv("XDG_STATE_HOME", stateHome)
+	os.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	binPath := t.TempDir()
+	dir := filepath.Join(binPath, "f39")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// written by an older version
+	legacy := []byte(`{"prefix": "f39", "shim_mode": "script", "shims": {"f39-gcc": {"target": "/usr/bin/gcc"}}}`)
+	if err := os.WriteFile(filepath.Join(dir, LegacyFileName), legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Shims["f39-gcc"].Target != "/usr/bin/gcc" {
+		t.Fatalf("read %+v from the legacy manifest", manifest)
+	}
+
+	if err := manifest.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, LegacyFileName)); !os.IsNotExist(err) {
+		t.Errorf("the legacy manifest is still in the prefix directory: %v", err)
+	}
+
+	newDir := filepath.Join(binPath, ".f39.btbNew")
+	if err := os.Rename(dir, newDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rename(dir, newDir); err != nil {
+		t.Fatal(err)
+	}
+	if Exists(dir) || !Exists(newDir) {
+		t.Errorf("the manifest did not move along with the directory")
+	}
+
+	if manifest, err = Read(newDir); err != nil || manifest.Dir != newDir {
+		t.Errorf("read %+v (%v) after renaming", manifest, err)
+	}
+
+	if err := Remove(newDir); err != nil {
+		t.Fatal(err)
+	}
+	if Exists(newDir) {
+		t.Errorf("the manifest was not removed")
+	}
+}
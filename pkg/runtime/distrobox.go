@@ -0,0 +1,57 @@
+/*
+ * Distrobox runtime. See https://github.com/89luca89/distrobox
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+import "strings"
+
+type Distrobox struct{}
+
+func init() {
+	Register(Distrobox{})
+}
+
+func (Distrobox) Name() string {
+	return "distrobox"
+}
+
+func (Distrobox) Detached() {}
+
+func (Distrobox) HostRoot() string {
+	return "/run/host"
+}
+
+func (Distrobox) Command(container string, args ...string) []string {
+	return append([]string{"distrobox", "enter", "-n", container, "--"}, args...)
+}
+
+func (Distrobox) EnvCommand(container string, env []string, args ...string) []string {
+	flags := make([]string, len(env))
+	for i, name := range env {
+		flags[i] = "--env " + name
+	}
+
+	command := []string{"distrobox", "enter", "-n", container, "--additional-flags", strings.Join(flags, " "), "--"}
+	return append(command, args...)
+}
+
+// Distrobox containers are podman or docker containers
+func (Distrobox) ExistsCommand(container string) []string {
+	return []string{"sh", "-c", `podman container exists "$1" 2>/dev/null ||
+docker container inspect "$1" >/dev/null 2>&1`, "sh", container}
+}
+
+func (Distrobox) ImageCommand(container string) []string {
+	return []string{"sh", "-c", `podman inspect -f {{.Image}} "$1" 2>/dev/null ||
+docker inspect -f {{.Image}} "$1"`, "sh", container}
+}
+
+func (Distrobox) ListCommand() []string {
+	return []string{"sh", "-c", `format={{.Names}}
+podman ps -a --filter label=manager=distrobox --format "$format" 2>/dev/null ||
+docker ps -a --filter label=manager=distrobox --format "$format"`}
+}
@@ -0,0 +1,89 @@
+/*
+ * Docker runtimes. Docker exec runs commands in an already running
+ * container while docker run starts a throwaway container from an image.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+type Docker struct{}
+
+type DockerRun struct{}
+
+func init() {
+	Register(Docker{})
+	Register(DockerRun{})
+}
+
+func (Docker) Name() string {
+	return "docker"
+}
+
+func (Docker) Detached() {}
+
+// Interactive commands get a terminal, see ttyScript
+func (Docker) Command(container string, args ...string) []string {
+	return ttyCommand(append([]string{"docker", "exec", "-i", container}, args...)...)
+}
+
+func (Docker) EnvCommand(container string, env []string, args ...string) []string {
+	command := []string{"docker", "exec", "-i"}
+	for _, name := range env {
+		command = append(command, "--env", name)
+	}
+
+	return ttyCommand(append(append(command, container), args...)...)
+}
+
+func (Docker) ExistsCommand(container string) []string {
+	return []string{"docker", "container", "inspect", container}
+}
+
+func (Docker) RunningCommand(container string) []string {
+	return []string{"docker", "inspect", "-f", "{{.State.Running}}", container}
+}
+
+func (Docker) StartCommand(container string) []string {
+	return []string{"docker", "start", container}
+}
+
+func (Docker) ImageCommand(container string) []string {
+	return []string{"docker", "inspect", "-f", "{{.Image}}", container}
+}
+
+func (Docker) ListCommand() []string {
+	return []string{"docker", "ps", "-a", "--format", "{{.Names}}"}
+}
+
+func (DockerRun) Name() string {
+	return "docker-run"
+}
+
+// For docker-run the container is the image to run
+func (DockerRun) Command(image string, args ...string) []string {
+	return ttyCommand(append([]string{"docker", "run", "--rm", "-i", image}, args...)...)
+}
+
+func (DockerRun) EnvCommand(image string, env []string, args ...string) []string {
+	command := []string{"docker", "run", "--rm", "-i"}
+	for _, name := range env {
+		command = append(command, "--env", name)
+	}
+
+	return ttyCommand(append(append(command, image), args...)...)
+}
+
+func (DockerRun) ExistsCommand(image string) []string {
+	return []string{"docker", "image", "inspect", image}
+}
+
+// The container is the image itself
+func (DockerRun) ImageCommand(image string) []string {
+	return []string{"docker", "image", "inspect", "-f", "{{.Id}}", image}
+}
+
+func (DockerRun) ListCommand() []string {
+	return []string{"docker", "image", "ls", "--filter", "dangling=false", "--format", "{{.Repository}}:{{.Tag}}"}
+}
@@ -0,0 +1,59 @@
+/*
+ * Flatpak runtime. Exports the installed Flatpak applications, which run
+ * with flatpak run, with the installation, user or system, as the
+ * container. The executables are the launchers flatpak exports for every
+ * application, named by its ID, eg. org.mozilla.firefox.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+type Flatpak struct{}
+
+func init() {
+	Register(Flatpak{})
+}
+
+func (Flatpak) Name() string {
+	return "flatpak"
+}
+
+// Runs the applications of the installation with flatpak run and
+// anything else, eg. the scripts btb scans with, on the host
+func (Flatpak) Command(installation string, args ...string) []string {
+	i := commandIndex(args)
+	if i < len(args) && strings.Contains(args[i], "/flatpak/exports/bin/") {
+		command := append(append([]string{}, args[:i]...), "flatpak", "run", "--"+installation,
+			filepath.Base(args[i]))
+		return append(command, args[i+1:]...)
+	}
+
+	return args
+}
+
+// Not an EnvRunner since flatpak run only sets variables. Those given by
+// name only are left out with a warning, NAME=value sets them.
+
+func (Flatpak) SearchCommand(installation string) []string {
+	if installation == "user" {
+		return []string{"echo", "~/.local/share/flatpak/exports/bin"}
+	}
+
+	return []string{"echo", "/var/lib/flatpak/exports/bin"}
+}
+
+// Fails for anything but user and system
+func (Flatpak) ExistsCommand(installation string) []string {
+	return []string{"flatpak", "--" + installation, "list", "--app"}
+}
+
+func (Flatpak) ListCommand() []string {
+	return []string{"printf", `%s\n`, "system", "user"}
+}
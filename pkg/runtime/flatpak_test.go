@@ -0,0 +1,26 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlatpakCommand(t *testing.T) {
+	firefox := "/var/lib/flatpak/exports/bin/org.mozilla.firefox"
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{firefox}, []string{"flatpak", "run", "--system", "org.mozilla.firefox"}},
+		{[]string{firefox, "--new-window"}, []string{"flatpak", "run", "--system", "org.mozilla.firefox", "--new-window"}},
+		{[]string{"env", "MOZ_ENABLE_WAYLAND=1", firefox},
+			[]string{"env", "MOZ_ENABLE_WAYLAND=1", "flatpak", "run", "--system", "org.mozilla.firefox"}},
+		{[]string{"sh", "-c", "exit 0"}, []string{"sh", "-c", "exit 0"}},
+	}
+
+	for _, test := range tests {
+		if got := (Flatpak{}).Command("system", test.args...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Command(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
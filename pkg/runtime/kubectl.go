@@ -0,0 +1,79 @@
+/*
+ * Kubernetes runtime. Runs commands in a pod with kubectl exec. The
+ * container is a selector: POD, NAMESPACE/POD, or NAMESPACE/POD/CONTAINER
+ * for pods with more than one container. The current namespace of
+ * kubectl is used when none is given.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+import "strings"
+
+type Kubectl struct{}
+
+func init() {
+	Register(Kubectl{})
+}
+
+// Returns the namespace flags and the pod of selector along with the
+// container flags
+func kubectlTarget(selector string) ([]string, string, []string) {
+	var namespace, container []string
+	parts := strings.SplitN(selector, "/", 3)
+	if len(parts) > 1 {
+		namespace = []string{"--namespace", parts[0]}
+		parts = parts[1:]
+	}
+	if len(parts) > 1 {
+		container = []string{"--container", parts[1]}
+	}
+
+	return namespace, parts[0], container
+}
+
+func (Kubectl) Name() string {
+	return "kubectl"
+}
+
+func (Kubectl) Remote() {}
+
+func (Kubectl) Detached() {}
+
+func (Kubectl) Command(selector string, args ...string) []string {
+	namespace, pod, container := kubectlTarget(selector)
+
+	command := append([]string{"kubectl", "exec", "-i"}, namespace...)
+	command = append(append(append(command, pod), container...), "--")
+	return ttyCommand(append(command, args...)...)
+}
+
+// Not an EnvRunner since kubectl exec cannot pass variables along. Those
+// given by name only are left out with a warning, NAME=value sets them.
+
+func (Kubectl) ExistsCommand(selector string) []string {
+	namespace, pod, _ := kubectlTarget(selector)
+	return append(append([]string{"kubectl", "get", "pod"}, namespace...), pod)
+}
+
+// The image of the container, or of every container of the pod if it was
+// not given
+func (Kubectl) ImageCommand(selector string) []string {
+	namespace, pod, container := kubectlTarget(selector)
+
+	filter := "*"
+	if container != nil {
+		filter = `?(@.name=="` + container[1] + `")`
+	}
+
+	command := append([]string{"kubectl", "get", "pod"}, namespace...)
+	return append(command, pod, "--output", "jsonpath={.status.containerStatuses["+filter+"].imageID}")
+}
+
+// The pods of every namespace as NAMESPACE/POD
+func (Kubectl) ListCommand() []string {
+	return []string{"kubectl", "get", "pods", "--all-namespaces", "--output",
+		`jsonpath={range .items[*]}{.metadata.namespace}/{.metadata.name}{"\n"}{end}`}
+}
@@ -0,0 +1,24 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKubectlCommand(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{"db", []string{"db", "--", "psql"}},
+		{"prod/db-0", []string{"--namespace", "prod", "db-0", "--", "psql"}},
+		{"prod/db-0/postgres", []string{"--namespace", "prod", "db-0", "--container", "postgres", "--", "psql"}},
+	}
+
+	for _, test := range tests {
+		command := Kubectl{}.Command(test.selector, "psql")
+		if got := command[7:]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Command(%q) runs kubectl exec %q, want %q", test.selector, got, test.want)
+		}
+	}
+}
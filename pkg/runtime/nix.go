@@ -0,0 +1,60 @@
+/*
+ * Nix runtime. Exports the commands of a nix profile, eg. ~/.nix-profile,
+ * or of what a flake builds, eg. nixpkgs#hello, as the container. Flake
+ * commands run with nix shell so they are built or fetched again once
+ * garbage collected, which needs the nix-command and flakes features.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+type Nix struct{}
+
+func init() {
+	Register(Nix{})
+}
+
+// Reports if container is a profile directory rather than a flake
+func nixProfile(container string) bool {
+	return strings.HasPrefix(container, "/") || strings.HasPrefix(container, "~/")
+}
+
+func (Nix) Name() string {
+	return "nix"
+}
+
+// Runs the commands of a flake with nix shell and anything else, eg. the
+// commands of a profile and the scripts btb scans with, on the host
+func (Nix) Command(container string, args ...string) []string {
+	if nixProfile(container) {
+		return args
+	}
+
+	i := commandIndex(args)
+	if i < len(args) && strings.HasPrefix(args[i], "/nix/store/") {
+		command := append(append([]string{}, args[:i]...), "nix", "shell", container, "--command",
+			filepath.Base(args[i]))
+		return append(command, args[i+1:]...)
+	}
+
+	return args
+}
+
+// Not an EnvRunner since the commands run on the host and so get every
+// variable anyway
+
+// The bin directory of the profile or of the outputs of the flake
+func (Nix) SearchCommand(container string) []string {
+	if nixProfile(container) {
+		return []string{"echo", strings.TrimSuffix(container, "/") + "/bin"}
+	}
+
+	return []string{"sh", "-c", `nix build --no-link --print-out-paths "$1" | sed 's|$|/bin|'`, "sh", container}
+}
@@ -0,0 +1,28 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNixCommand(t *testing.T) {
+	hello := "/nix/store/0c5k4ja0vbb2v6v0p6bzcx2w9ydz0dqb-hello-2.12.1/bin/hello"
+	tests := []struct {
+		container string
+		args      []string
+		want      []string
+	}{
+		{"nixpkgs#hello", []string{hello, "-g", "hi"},
+			[]string{"nix", "shell", "nixpkgs#hello", "--command", "hello", "-g", "hi"}},
+		{"nixpkgs#hello", []string{"env", "LANG=C", hello},
+			[]string{"env", "LANG=C", "nix", "shell", "nixpkgs#hello", "--command", "hello"}},
+		{"nixpkgs#hello", []string{"sh", "-c", "exit 0"}, []string{"sh", "-c", "exit 0"}},
+		{"~/.nix-profile", []string{"/home/user/.nix-profile/bin/hello"}, []string{"/home/user/.nix-profile/bin/hello"}},
+	}
+
+	for _, test := range tests {
+		if got := (Nix{}).Command(test.container, test.args...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Command(%q, %q) = %q, want %q", test.container, test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Podman runtimes. Podman exec runs commands directly in an already
+ * running container, skipping the startup cost of toolbox, while podman
+ * run starts a throwaway container from an image for every command.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+// With root set, eg. to sudo, runs podman as root, see AsRoot
+type Podman struct {
+	root string
+}
+
+type PodmanRun struct {
+	root string
+}
+
+func init() {
+	Register(Podman{})
+	Register(PodmanRun{})
+}
+
+func (podman Podman) Name() string {
+	return rootName(podman.root, "podman")
+}
+
+func (Podman) AsRoot(tool string) Runtime {
+	return Podman{root: tool}
+}
+
+func (Podman) Detached() {}
+
+// Interactive commands get a terminal, see ttyScript
+func (podman Podman) Command(container string, args ...string) []string {
+	return rootCommand(podman.root, ttyCommand(append([]string{"podman", "exec", "-i", container}, args...)...))
+}
+
+func (podman Podman) EnvCommand(container string, env []string, args ...string) []string {
+	command := []string{"podman", "exec", "-i"}
+	for _, name := range env {
+		command = append(command, "--env", name)
+	}
+
+	return rootEnvCommand(podman.root, env, ttyCommand(append(append(command, container), args...)...))
+}
+
+func (podman Podman) ExistsCommand(container string) []string {
+	return rootCommand(podman.root, []string{"podman", "container", "exists", container})
+}
+
+func (podman Podman) RunningCommand(container string) []string {
+	return rootCommand(podman.root, []string{"podman", "inspect", "-f", "{{.State.Running}}", container})
+}
+
+func (podman Podman) StartCommand(container string) []string {
+	return rootCommand(podman.root, []string{"podman", "start", container})
+}
+
+func (podman Podman) ListCommand() []string {
+	return rootCommand(podman.root, []string{"podman", "ps", "-a", "--format", "{{.Names}}"})
+}
+
+func (podman Podman) ImageCommand(container string) []string {
+	return rootCommand(podman.root, []string{"podman", "inspect", "-f", "{{.Image}}", container})
+}
+
+func (podman PodmanRun) Name() string {
+	return rootName(podman.root, "podman-run")
+}
+
+func (PodmanRun) AsRoot(tool string) Runtime {
+	return PodmanRun{root: tool}
+}
+
+// For podman-run the container is the image to run
+func (podman PodmanRun) Command(image string, args ...string) []string {
+	return rootCommand(podman.root, ttyCommand(append([]string{"podman", "run", "--rm", "-i", image}, args...)...))
+}
+
+func (podman PodmanRun) EnvCommand(image string, env []string, args ...string) []string {
+	command := []string{"podman", "run", "--rm", "-i"}
+	for _, name := range env {
+		command = append(command, "--env", name)
+	}
+
+	return rootEnvCommand(podman.root, env, ttyCommand(append(append(command, image), args...)...))
+}
+
+func (podman PodmanRun) ExistsCommand(image string) []string {
+	return rootCommand(podman.root, []string{"podman", "image", "exists", image})
+}
+
+// The container is the image itself
+func (podman PodmanRun) ImageCommand(image string) []string {
+	return rootCommand(podman.root, []string{"podman", "image", "inspect", "-f", "{{.Id}}", image})
+}
+
+func (podman PodmanRun) ListCommand() []string {
+	return rootCommand(podman.root, []string{"podman", "images", "--filter", "dangling=false",
+		"--format", "{{.Repository}}:{{.Tag}}"})
+}
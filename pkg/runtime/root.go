@@ -0,0 +1,84 @@
+/*
+ * Rootful containers. Some runtimes can run their commands with sudo or
+ * pkexec to reach the containers of root instead of those of the user.
+ * The name of such a runtime has the tool in front of it, eg.
+ * sudo:toolbox, so shims and manifests keep running commands as root.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rootful is implemented by runtimes that can run their commands as root
+type Rootful interface {
+	// AsRoot returns the runtime running its commands with tool
+	AsRoot(tool string) Runtime
+}
+
+// Tools runtimes can run their commands as root with
+var RootTools = []string{"sudo", "pkexec"}
+
+// SplitRoot returns the tool and the runtime of a name like sudo:toolbox,
+// or an empty tool for runtimes that do not run commands as root
+func SplitRoot(name string) (string, string) {
+	for _, tool := range RootTools {
+		if strings.HasPrefix(name, tool+":") {
+			return tool, strings.TrimPrefix(name, tool+":")
+		}
+	}
+
+	return "", name
+}
+
+// AsRoot returns rt running its commands as root with tool
+func AsRoot(rt Runtime, tool string) (Runtime, error) {
+	known := false
+	for _, rootTool := range RootTools {
+		known = known || tool == rootTool
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown tool %q to run commands as root with (available: %s)",
+			tool, strings.Join(RootTools, ", "))
+	}
+
+	rootful, ok := rt.(Rootful)
+	if !ok {
+		return nil, fmt.Errorf("runtime %s cannot run commands as root", rt.Name())
+	}
+
+	return rootful.AsRoot(tool), nil
+}
+
+// Returns the name of a runtime running its commands with tool
+func rootName(tool string, name string) string {
+	if tool == "" {
+		return name
+	}
+
+	return tool + ":" + name
+}
+
+// Returns command run with tool, eg. sudo
+func rootCommand(tool string, command []string) []string {
+	if tool == "" {
+		return command
+	}
+
+	return append([]string{tool}, command...)
+}
+
+// Returns command run with tool, keeping the variables named by env for
+// it. Only sudo can, pkexec always clears the environment.
+func rootEnvCommand(tool string, env []string, command []string) []string {
+	if tool != "sudo" || len(env) == 0 {
+		return rootCommand(tool, command)
+	}
+
+	return append([]string{tool, "--preserve-env=" + strings.Join(env, ",")}, command...)
+}
@@ -0,0 +1,164 @@
+/*
+ * Container runtimes that shims can be generated for.
+ *
+ * A runtime knows how to run a command inside of a named container.
+ * Both the generated shims and the scripts btb scans the container with
+ * are built from the command a runtime produces.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Runtime interface {
+	// Name used to select the runtime, eg. toolbox
+	Name() string
+	// Command returns the argument list that runs args inside of container
+	Command(container string, args ...string) []string
+}
+
+// Starter is implemented by runtimes that fail to run commands in a
+// stopped container rather than starting it first
+type Starter interface {
+	// RunningCommand returns the argument list that prints true if
+	// container is running
+	RunningCommand(container string) []string
+	// StartCommand returns the argument list that starts container
+	StartCommand(container string) []string
+}
+
+// Checker is implemented by runtimes that can tell if a container
+// exists without running anything inside of it
+type Checker interface {
+	// ExistsCommand returns the argument list that succeeds if container
+	// exists
+	ExistsCommand(container string) []string
+}
+
+// EnvRunner is implemented by runtimes that can pass environment
+// variables of the caller to the command they run
+type EnvRunner interface {
+	// EnvCommand is like Command and also passes the variables named by
+	// env along
+	EnvCommand(container string, env []string, args ...string) []string
+}
+
+// Lister is implemented by runtimes that can list their containers
+type Lister interface {
+	// ListCommand returns the argument list that prints the name of every
+	// container, one per line
+	ListCommand() []string
+}
+
+// Imager is implemented by runtimes that can tell which image a
+// container was created from
+type Imager interface {
+	// ImageCommand returns the argument list that prints the ID of the
+	// image of container
+	ImageCommand(container string) []string
+}
+
+// Searcher is implemented by runtimes whose commands are not found on
+// the PATH of the container
+type Searcher interface {
+	// SearchCommand returns the argument list that prints the
+	// directories to look for commands in instead, one per line
+	SearchCommand(container string) []string
+}
+
+// Remote is implemented by runtimes that run commands on another machine,
+// so btb running inside of a container does not make it the one meant
+type Remote interface {
+	// Remote only marks the runtime
+	Remote()
+}
+
+// Detached is implemented by runtimes that leave the command running in
+// the container when the command they run it with is killed, eg. podman
+// exec without a terminal, so shims forward signals to it themselves
+type Detached interface {
+	// Detached only marks the runtime
+	Detached()
+}
+
+// HostMounter is implemented by runtimes whose containers share the home
+// directory of the host and have its root directory mounted, so host
+// paths can be translated for commands in the container
+type HostMounter interface {
+	// HostRoot returns where the root directory of the host is in the
+	// container
+	HostRoot() string
+}
+
+const Default = "toolbox"
+
+// Runs a program with a subcommand, eg. kubectl exec, with -t added when
+// both stdin and stdout are a terminal. A terminal is requested only then
+// since the programs complain otherwise and it mixes stderr into stdout.
+const ttyScript = `program=$1 subcommand=$2
+shift 2
+if [ -t 0 ] && [ -t 1 ]; then
+	exec "$program" "$subcommand" -t "$@"
+fi
+exec "$program" "$subcommand" "$@"
+`
+
+// Returns the index of the command in args after the variables that
+// shim.Command sets with env
+func commandIndex(args []string) int {
+	if len(args) == 0 || args[0] != "env" {
+		return 0
+	}
+
+	i := 1
+	for i < len(args) && strings.Contains(args[i], "=") {
+		i++
+	}
+
+	return i
+}
+
+// Returns the argument list that runs command through ttyScript
+func ttyCommand(command ...string) []string {
+	return append([]string{"sh", "-c", ttyScript, "sh"}, command...)
+}
+
+var runtimes = make(map[string]Runtime)
+
+func Register(runtime Runtime) {
+	runtimes[runtime.Name()] = runtime
+}
+
+func Get(name string) (Runtime, error) {
+	if tool, base := SplitRoot(name); tool != "" {
+		runtime, err := Get(base)
+		if err != nil {
+			return nil, err
+		}
+		return AsRoot(runtime, tool)
+	}
+
+	runtime, ok := runtimes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown runtime %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+
+	return runtime, nil
+}
+
+func Names() []string {
+	names := make([]string, 0, len(runtimes))
+	for name := range runtimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
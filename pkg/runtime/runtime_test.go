@@ -0,0 +1,43 @@
+package runtime
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestTTYCommand(t *testing.T) {
+	// the test has no terminal, so commands run without -t
+	command := ttyCommand("echo", "exec", "-i", "f35", "jq", ".")
+	output, err := exec.Command(command[0], command[1:]...).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.TrimSpace(string(output)); got != "exec -i f35 jq ." {
+		t.Errorf("without a terminal %v runs echo %s", command, got)
+	}
+}
+
+func TestRoot(t *testing.T) {
+	rt, err := Get("sudo:podman")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rt.Name() != "sudo:podman" {
+		t.Errorf("sudo:podman is named %s", rt.Name())
+	}
+	if command := rt.(Checker).ExistsCommand("f35"); strings.Join(command, " ") != "sudo podman container exists f35" {
+		t.Errorf("sudo:podman checks for containers with %v", command)
+	}
+	if command := rt.(EnvRunner).EnvCommand("f35", []string{"TERM"}, "jq"); command[1] != "--preserve-env=TERM" {
+		t.Errorf("sudo:podman drops the variables of %v", command)
+	}
+
+	for _, name := range []string{"pkexec:ssh", "doas:toolbox", "sudo:unknown"} {
+		if _, err := Get(name); err == nil {
+			t.Errorf("%s is a runtime", name)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * SSH runtime. Runs commands on another machine, eg. a build server, with
+ * the host name or ssh config alias as the container.
+ *
+ * ssh joins the command into one line for the shell of the remote user,
+ * so a local sh quotes every argument for it first. The remote login
+ * shell has to be a POSIX shell for that. A terminal is requested only
+ * when both stdin and stdout are one so pipes stay clean.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+import "strings"
+
+type SSH struct{}
+
+func init() {
+	Register(SSH{})
+}
+
+// Run with the host, the variables to send, and the command as arguments
+const sshScript = `host=$1 sendenv=$2
+shift 2
+for arg; do
+	quoted=$(printf '%sx' "$arg" | sed "s/'/'\\\\''/g")
+	set -- "$@" "'${quoted%x}'"
+	shift
+done
+if [ -n "$sendenv" ]; then
+	set -- -o "SendEnv=$sendenv" -- "$host" "$@"
+else
+	set -- -- "$host" "$@"
+fi
+if [ -t 0 ] && [ -t 1 ]; then
+	exec ssh -t "$@"
+fi
+exec ssh -T "$@"
+`
+
+func (SSH) Name() string {
+	return "ssh"
+}
+
+func (SSH) Remote() {}
+
+func (SSH) Command(host string, args ...string) []string {
+	return append([]string{"sh", "-c", sshScript, "ssh", host, ""}, args...)
+}
+
+// The variables are only set if the server accepts them, see AcceptEnv
+// in sshd_config(5)
+func (SSH) EnvCommand(host string, env []string, args ...string) []string {
+	return append([]string{"sh", "-c", sshScript, "ssh", host, strings.Join(env, " ")}, args...)
+}
+
+// Succeeds if the host can be logged in to without a prompt
+func (SSH) ExistsCommand(host string) []string {
+	return []string{"ssh", "-o", "BatchMode=yes", "-o", "ConnectTimeout=10", "--", host, "true"}
+}
+
+// The hosts named in the ssh config, without patterns
+func (SSH) ListCommand() []string {
+	return []string{"sh", "-c", `sed -n 's/^[[:space:]]*[Hh][Oo][Ss][Tt][[:space:]]\{1,\}//p' ~/.ssh/config 2>/dev/null |
+tr ' \t' '\n\n' | grep -v -e '^$' -e '[*?!]' | sort -u
+exit 0`}
+}
@@ -0,0 +1,44 @@
+package runtime
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Runs the remote command with sh like sshd does
+const fakeSSH = `#!/bin/sh
+while [ "$1" != -- ]; do shift; done
+shift 2
+exec sh -c "$*"
+`
+
+// Arguments reach the remote command as they were given
+func TestSSHCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(fakeSSH), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	args := []string{"", "a b", "it's", "$(id)", "`id`", "new\nline\n", "*", `back\slash`}
+	for _, command := range [][]string{
+		SSH{}.Command("build", "printf", "%s|"),
+		SSH{}.EnvCommand("build", []string{"CC", "CFLAGS"}, "printf", "%s|"),
+	} {
+		// as the shim appends its own arguments
+		command = append(command, args...)
+		output, err := exec.Command(command[0], command[1:]...).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "|a b|it's|$(id)|`id`|new\nline\n|*|back\\slash|"; string(output) != want {
+			t.Errorf("got %q, want %q", output, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Toolbox runtime. See https://github.com/containers/toolbox
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+// With root set, eg. to sudo, runs toolbox and podman as root, see AsRoot
+type Toolbox struct {
+	root string
+}
+
+func init() {
+	Register(Toolbox{})
+}
+
+func (toolbox Toolbox) Name() string {
+	return rootName(toolbox.root, "toolbox")
+}
+
+func (Toolbox) AsRoot(tool string) Runtime {
+	return Toolbox{root: tool}
+}
+
+func (Toolbox) Detached() {}
+
+func (Toolbox) HostRoot() string {
+	return "/run/host"
+}
+
+func (toolbox Toolbox) Command(container string, args ...string) []string {
+	return rootCommand(toolbox.root, append([]string{"toolbox", "run", "-c", container}, args...))
+}
+
+// Not an EnvRunner since toolbox passes the display, D-Bus, and audio
+// variables along by itself but no others. Variables given by name only,
+// eg. with --env MOZ_ENABLE_WAYLAND, are left out of toolbox shims with
+// a warning, only NAME=value sets them.
+
+// Toolbox containers are podman containers
+func (toolbox Toolbox) ExistsCommand(container string) []string {
+	return rootCommand(toolbox.root, []string{"podman", "container", "exists", container})
+}
+
+func (toolbox Toolbox) ImageCommand(container string) []string {
+	return rootCommand(toolbox.root, []string{"podman", "inspect", "-f", "{{.Image}}", container})
+}
+
+// The containers toolbox list --containers shows, without its table
+func (toolbox Toolbox) ListCommand() []string {
+	return rootCommand(toolbox.root, []string{"podman", "ps", "-a", "--filter",
+		"label=com.github.containers.toolbox=true", "--format", "{{.Names}}"})
+}
@@ -0,0 +1,36 @@
+/*
+ * WSL runtime. Runs commands in a distribution of the Windows Subsystem
+ * for Linux with the distribution as the container. Shims run from
+ * Windows are generated with --shim-format cmd or powershell.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package runtime
+
+type WSL struct{}
+
+func init() {
+	Register(WSL{})
+}
+
+func (WSL) Name() string {
+	return "wsl"
+}
+
+func (WSL) Remote() {}
+
+// --exec runs args without a shell splitting them again
+func (WSL) Command(distro string, args ...string) []string {
+	return append([]string{"wsl.exe", "--distribution", distro, "--exec"}, args...)
+}
+
+// Not an EnvRunner, variables given by name only are left out with a
+// warning. See WSLENV for sharing them between Windows and WSL.
+
+// wsl.exe prints UTF-16 unless WSL_UTF8 is set, which older versions
+// ignore
+func (WSL) ListCommand() []string {
+	return []string{"sh", "-c", `wsl.exe --list --quiet | tr -d '\000\r'`}
+}
@@ -0,0 +1,659 @@
+/*
+ * Shim scripts. A shim runs a single executable inside of a container
+ * and records what it runs in comments so btb can read it back.
+ *
+ * Shims are rendered from a text/template with the fields of Data and
+ * a quote function for shell quoting. See DefaultTemplate. Shims run by
+ * cmd.exe or PowerShell on Windows, eg. for WSL, have their own
+ * templates, see Formats.
+ *
+ * Shims record the FormatVersion of the templates they were rendered
+ * from, so that btb migrate can rewrite those of an older btb.
+ *
+ * Author: A.C. Minor
+ * SPDX identifier: BSD-3-Clause
+ */
+
+package shim
+
+import (
+	"btb/pkg/runtime"
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type Info struct {
+	Container string `json:"container"`
+	Runtime   string `json:"runtime"`
+	Target    string `json:"target"`
+	// FormatVersion of the shim, 0 for shims of before it was recorded
+	Format int `json:"format"`
+}
+
+type Data struct {
+	// FormatVersion, recorded in the btb comments
+	Format     int
+	Container  string
+	Runtime    string
+	TargetPath string
+	Exe        string
+	// Quoted command that runs TargetPath inside of Container
+	Command string
+	// Command unquoted, for templates of other shells to quote
+	Argv []string
+	// Line that starts Container if it is stopped, empty if the runtime
+	// does that itself. See StartScript.
+	Start string
+	// Lines that run Exe from the host instead when Container does not
+	// exist, empty unless enabled. See FallbackScript.
+	Fallback string
+	// Lines that keep Container running for a while after the shim ran,
+	// empty unless enabled. See KeepAliveScript.
+	KeepAlive string
+	// Lines that check the working directory is one Container has, empty
+	// unless the runtime keeps it. See CwdScript.
+	Cwd string
+	// Lines that translate host paths in the arguments for Container,
+	// empty unless enabled. See TranslateScript.
+	Translate string
+	// Variables of the command, NAME=value to set one or NAME to pass it
+	// along from the host
+	Env []string
+	// Arguments Command puts before those given to the shim
+	Args []string
+	// Command that Command runs TargetPath through in the container
+	Wrapper []string
+}
+
+// Version of the templates, increased whenever shims rendered from an
+// older one need to be rewritten. 2 runs them with sh instead of bash.
+const FormatVersion = 2
+
+const infoFormat = `# btb-format: {{.Format}}
+# btb-container: {{.Container}}
+# btb-runtime: {{.Runtime}}
+# btb-target: {{.TargetPath}}
+`
+
+// Shims are POSIX sh, which starts faster than bash and is there on
+// hosts without it
+const DefaultTemplate = `#!/bin/sh
+` + infoFormat + `
+{{if .Fallback}}{{.Fallback}}
+{{end}}{{if .Cwd}}{{.Cwd}}
+{{end}}{{if .Translate}}{{.Translate}}
+{{end}}{{if .Start}}{{.Start}}
+{{end}}{{if .KeepAlive}}{{.KeepAlive}}
+{{end}}exec {{.Command}} "$@"
+`
+
+// Shims for cmd.exe, which runs the command with the arguments of the shim
+// appended as they were given
+const CmdTemplate = `@echo off
+rem # btb-format: {{.Format}}
+rem # btb-container: {{.Container}}
+rem # btb-runtime: {{.Runtime}}
+rem # btb-target: {{.TargetPath}}
+{{range .Argv}}{{quoteCmd .}} {{end}}%*
+`
+
+// Shims for PowerShell, which exit with the exit code of the command
+const PowerShellTemplate = infoFormat + `
+& {{range $i, $arg := .Argv}}{{if $i}} {{end}}{{quotePowerShell $arg}}{{end}} @args
+exit $LASTEXITCODE
+`
+
+// Format of the shims run by a shell of the host
+type Format struct {
+	Template string
+	// Appended to the file names of the shims
+	Extension string
+}
+
+// Formats keyed by the shell that runs the shims
+var Formats = map[string]Format{
+	"sh":         {DefaultTemplate, ""},
+	"cmd":        {CmdTemplate, ".cmd"},
+	"powershell": {PowerShellTemplate, ".ps1"},
+}
+
+// Runs the executable named by the first argument from the host PATH,
+// skipping the directories btb manages
+const fallbackFunction = `btb_fallback() {
+	btb_exe=$1
+	shift
+	IFS=:
+	for btb_dir in $PATH; do
+		[ -e "$btb_dir/.btbMarker" ] && continue
+		[ -f "$btb_dir/$btb_exe" ] && [ -x "$btb_dir/$btb_exe" ] && exec "$btb_dir/$btb_exe" "$@"
+	done
+	echo "btb: the container is not available and there is no $btb_exe on the host" >&2
+	exit 127
+}
+`
+
+// Variable that tags the processes a shim runs in the container with the
+// PID of the shim, see signalScript
+const signalVariable = "BTB_SIGNAL"
+
+// Runs a command of a Detached runtime and forwards INT, TERM, and HUP
+// to it, exiting with its exit code. The first argument is the number of
+// arguments after it that signal the processes in the container, see
+// killScript, the rest runs the command with an empty signalVariable set
+// to the PID of the shim. The command runs in the background with the
+// same stdin, where sh ignores INT, so only the signal forwarded stops it.
+const signalScript = `count=$1
+shift
+btb_run() {
+	i=0
+	for arg; do
+		shift
+		if [ "$i" -ge "$count" ]; then
+			[ "$arg" = ` + signalVariable + `= ] && arg=` + signalVariable + `=$$
+			set -- "$@" "$arg"
+		fi
+		i=$((i + 1))
+	done
+	exec "$@"
+}
+btb_kill() {
+	signal=$1
+	shift
+	i=0
+	for arg; do
+		shift
+		[ "$i" -lt "$count" ] && set -- "$@" "$arg"
+		i=$((i + 1))
+	done
+	"$@" "$signal" "$$" </dev/null
+}
+exec 3<&0
+btb_run "$@" <&3 3<&- &
+child=$!
+exec 3<&-
+trap 'btb_kill INT "$@"' INT
+trap 'btb_kill TERM "$@"' TERM
+trap 'btb_kill HUP "$@"' HUP
+while :; do
+	wait "$child"
+	status=$?
+	kill -0 "$child" 2>/dev/null || exit "$status"
+done
+`
+
+// Sends the signal given as the first argument to every process in the
+// container tagged with the PID given as the second, see signalScript
+const killScript = `for dir in /proc/[0-9]*; do
+	tr '\0' '\n' <"$dir/environ" 2>/dev/null | grep -qx "` + signalVariable + `=$2" &&
+		kill -s "$1" "${dir#/proc/}" 2>/dev/null
+done
+exit 0
+`
+
+// Script every shim links to in the symlink shim mode
+const LauncherName = ".btb-launcher"
+
+const launcherTemplate = `#!/bin/sh
+# btb-launcher: {{.Container}}
+
+{{if .Cwd}}{{.Cwd}}
+{{end}}{{if .Start}}{{.Start}}
+{{end}}{{if .KeepAlive}}{{.KeepAlive}}
+{{end}}case "$(basename "$0")" in
+{{- range .Entries}}
+	{{.Name}}) exec {{.Command}} "$@" ;;
+{{- end}}
+	*) echo "btb: no command for $(basename "$0")" >&2; exit 127 ;;
+esac
+`
+
+type Renderer struct {
+	template *template.Template
+	info     *template.Template
+	// How long shims wait for a stopped container to start, 0 to not
+	// start it
+	StartTimeout time.Duration
+	// How long shims keep the container running after they ran, 0 to not
+	// keep it running
+	KeepAlive time.Duration
+	// Run the executable from the host when the container is missing
+	HostFallback bool
+	// Translate the absolute host paths given to shims for the container,
+	// with the HOST=CONTAINER prefixes of PathMap first and then those of
+	// a runtime.HostMounter
+	TranslatePaths bool
+	PathMap        []string
+	// What shims do when run outside of the home directory the containers
+	// of a runtime.HostMounter share, see CwdScript
+	OnUnsharedCwd string
+	// Variables of every command and of the commands keyed by executable
+	// name, NAME=value to set one or NAME to pass it along from the host
+	Env        []string
+	CommandEnv map[string][]string
+	// Arguments put before those given and commands run through inside
+	// of the container, keyed by executable name
+	CommandArgs    map[string][]string
+	CommandWrapper map[string][]string
+}
+
+// Variables graphical and audio applications need
+var GUIEnv = []string{"DISPLAY", "WAYLAND_DISPLAY", "XAUTHORITY", "DBUS_SESSION_BUS_ADDRESS", "PULSE_SERVER"}
+
+// Shims generated before the btb comments were added
+const legacyPrefix = "toolbox run -c "
+
+// NewRenderer parses a shim template. An empty text uses DefaultTemplate.
+func NewRenderer(text string) (*Renderer, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+
+	funcs := template.FuncMap{"quote": Quote, "quoteCmd": QuoteCmd, "quotePowerShell": QuotePowerShell}
+	shimTemplate, err := template.New("shim").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Renderer{
+		template: shimTemplate,
+		info:     template.Must(template.New("info").Parse(infoFormat)),
+	}, nil
+}
+
+func (renderer *Renderer) Render(rt runtime.Runtime, container string, target string) (string, error) {
+	command := renderer.Command(rt, container, target)
+	data := Data{
+		Format:     FormatVersion,
+		Container:  container,
+		Runtime:    rt.Name(),
+		TargetPath: target,
+		Exe:        filepath.Base(target),
+		Command:    QuoteAll(command),
+		Argv:       command,
+		Env:        renderer.commandEnv(target),
+		Args:       renderer.CommandArgs[filepath.Base(target)],
+		Wrapper:    renderer.CommandWrapper[filepath.Base(target)],
+	}
+
+	onFailure := "exit"
+	if renderer.HostFallback {
+		data.Fallback = FallbackScript(rt, container, data.Exe)
+		if data.Fallback != "" {
+			onFailure = "btb_fallback " + Quote(data.Exe) + ` "$@"`
+		}
+	}
+	data.Start = StartScript(rt, container, renderer.StartTimeout, onFailure)
+	data.KeepAlive = KeepAliveScript(rt, container, renderer.KeepAlive)
+
+	data.Cwd = CwdScript(rt, renderer.OnUnsharedCwd)
+	if renderer.TranslatePaths {
+		home, _ := os.UserHomeDir()
+		data.Translate = TranslateScript(rt, renderer.PathMap, home)
+	}
+
+	var contents strings.Builder
+	if err := renderer.template.Execute(&contents, data); err != nil {
+		return "", err
+	}
+
+	if _, ok := Parse([]byte(contents.String())); ok {
+		return contents.String(), nil
+	}
+
+	// templates without the btb comments get them after the shebang
+	var info strings.Builder
+	if err := renderer.info.Execute(&info, data); err != nil {
+		return "", err
+	}
+
+	lines := strings.SplitAfterN(contents.String(), "\n", 2)
+	if len(lines) == 1 || !strings.HasPrefix(lines[0], "#!") {
+		return info.String() + contents.String(), nil
+	}
+
+	return lines[0] + info.String() + lines[1], nil
+}
+
+// StartScript returns a line of sh that starts container within timeout
+// unless it is running and runs onFailure if that fails. Empty if rt
+// starts containers itself or timeout is not positive.
+func StartScript(rt runtime.Runtime, container string, timeout time.Duration, onFailure string) string {
+	starter, ok := rt.(runtime.Starter)
+	if !ok || timeout <= 0 {
+		return ""
+	}
+
+	seconds := int((timeout + time.Second - 1) / time.Second)
+	return fmt.Sprintf(`[ "$(%s 2>/dev/null)" = true ] || timeout %d %s >/dev/null || %s`,
+		QuoteAll(starter.RunningCommand(container)), seconds, QuoteAll(starter.StartCommand(container)), onFailure)
+}
+
+// Keeps a process running in the container as long as a shim of it ran
+// in the last $1 seconds, by running sleep in it again and again. The
+// second argument is the file the shims write the time they ran to, the
+// rest runs sleep in the container.
+const keepAliveScript = `seconds=$1 stamp=$2
+shift 2
+while [ $(($(date +%s) - $(cat "$stamp"))) -lt "$seconds" ]; do
+	"$@" sleep "$seconds" || break
+done
+rm -f "$stamp.pid"
+`
+
+// KeepAliveScript returns lines of sh that keep container running for
+// keepAlive after the shim ran, so the next command does not wait for it
+// to start again. One process in the background of the first shim keeps
+// it running for all of them. Empty if keepAlive is not positive.
+func KeepAliveScript(rt runtime.Runtime, container string, keepAlive time.Duration) string {
+	if keepAlive <= 0 {
+		return ""
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, rt.Name()+"-"+container)
+
+	seconds := int((keepAlive + time.Second - 1) / time.Second)
+	return fmt.Sprintf(`btb_alive=${XDG_RUNTIME_DIR:-/tmp}/btb-alive-$(id -u)-%s
+date +%%s >"$btb_alive"
+if ! kill -0 "$(cat "$btb_alive.pid" 2>/dev/null)" 2>/dev/null; then
+	sh -c %s sh %d "$btb_alive" %s </dev/null >/dev/null 2>&1 &
+	echo $! >"$btb_alive.pid"
+fi`, name, Quote(keepAliveScript), seconds, QuoteAll(rt.Command(container)))
+}
+
+// FallbackScript returns lines of sh that run exe from the host if
+// container does not exist. Empty if rt cannot tell.
+func FallbackScript(rt runtime.Runtime, container string, exe string) string {
+	checker, ok := rt.(runtime.Checker)
+	if !ok {
+		return ""
+	}
+
+	return fallbackFunction + fmt.Sprintf(`%s >/dev/null 2>&1 || btb_fallback %s "$@"`,
+		QuoteAll(checker.ExistsCommand(container)), Quote(exe))
+}
+
+// CwdScript returns lines of sh that run the command in the home
+// directory with a warning when the working directory is outside of it
+// for the policy home, or exit with an error for the policy error, since
+// the container of a runtime.HostMounter only has the home directory of
+// the host at the same path. Empty for other runtimes or policies.
+func CwdScript(rt runtime.Runtime, policy string) string {
+	if _, ok := rt.(runtime.HostMounter); !ok {
+		return ""
+	}
+
+	var action string
+	switch policy {
+	case "home":
+		action = `echo "btb: $PWD is not in the container, running in $HOME" >&2; cd "$HOME" || exit`
+	case "error":
+		action = `echo "btb: $PWD is not in the container, cd to a directory in $HOME" >&2; exit 1`
+	default:
+		return ""
+	}
+
+	return `case $PWD in "$HOME" | "$HOME"/*) ;; *) ` + action + ` ;; esac`
+}
+
+// TranslateScript returns lines of sh that replace the HOST=CONTAINER
+// prefixes of pathMap in the absolute paths given as arguments, and then
+// for a runtime.HostMounter keep those in home and prefix the others with
+// the mount of the root directory. Paths that match none but exist on the
+// host get a warning since the container may not have them.
+func TranslateScript(rt runtime.Runtime, pathMap []string, home string) string {
+	mappings := append([]string{}, pathMap...)
+	if mounter, ok := rt.(runtime.HostMounter); ok {
+		if home != "" {
+			mappings = append(mappings, home+"="+home)
+		}
+		mappings = append(mappings, "/="+mounter.HostRoot())
+	}
+
+	var script strings.Builder
+	script.WriteString("for btb_arg; do\n\tshift\n\tcase $btb_arg in\n")
+	for _, mapping := range mappings {
+		fields := strings.SplitN(mapping, "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		host, inContainer := strings.TrimSuffix(fields[0], "/"), strings.TrimSuffix(fields[1], "/")
+
+		pattern := "/*"
+		if host != "" {
+			pattern = Quote(host) + "|" + Quote(host) + "/*"
+		}
+
+		if host == inContainer {
+			fmt.Fprintf(&script, "\t%s) ;;\n", pattern)
+		} else {
+			fmt.Fprintf(&script, "\t%s) btb_arg=%s${btb_arg#%s} ;;\n", pattern, Quote(inContainer), Quote(host))
+		}
+	}
+	script.WriteString(`	/*) [ -e "$btb_arg" ] && echo "btb: $btb_arg may not be in the container" >&2 ;;
+	esac
+	set -- "$@" "$btb_arg"
+done`)
+
+	return script.String()
+}
+
+// Returns the variables of Env and CommandEnv for target
+func (renderer *Renderer) commandEnv(target string) []string {
+	env := append([]string{}, renderer.Env...)
+	return append(env, renderer.CommandEnv[filepath.Base(target)]...)
+}
+
+// Command returns the argument list that runs target inside of container
+// with the variables of Env and CommandEnv, through its CommandWrapper,
+// and with its CommandArgs
+func (renderer *Renderer) Command(rt runtime.Runtime, container string, target string) []string {
+	exe := filepath.Base(target)
+	command := append(append([]string{}, renderer.CommandWrapper[exe]...), target)
+	command = append(command, renderer.CommandArgs[exe]...)
+
+	return Command(rt, container, renderer.commandEnv(target), command...)
+}
+
+// DroppedEnv returns the variables of env given as NAME that Command
+// leaves out since the runtime cannot pass them along
+func DroppedEnv(rt runtime.Runtime, env []string) []string {
+	if _, ok := rt.(runtime.EnvRunner); ok {
+		return nil
+	}
+
+	var dropped []string
+	for _, variable := range env {
+		if !strings.Contains(variable, "=") {
+			dropped = append(dropped, variable)
+		}
+	}
+
+	return dropped
+}
+
+// Command returns the argument list that runs args inside of container
+// with the variables of env. Those given as NAME=value are set with env
+// inside of the container, those given as NAME are passed along if the
+// runtime is an EnvRunner and left out otherwise, see DroppedEnv. The
+// commands of Detached runtimes run through signalScript.
+func Command(rt runtime.Runtime, container string, env []string, args ...string) []string {
+	var passed, set []string
+	for _, variable := range env {
+		if strings.Contains(variable, "=") {
+			set = append(set, variable)
+		} else {
+			passed = append(passed, variable)
+		}
+	}
+
+	_, detached := rt.(runtime.Detached)
+	if detached {
+		set = append(set, signalVariable+"=")
+	}
+
+	if len(set) != 0 {
+		args = append(append([]string{"env"}, set...), args...)
+	}
+
+	var command []string
+	if envRunner, ok := rt.(runtime.EnvRunner); ok && len(passed) != 0 {
+		command = envRunner.EnvCommand(container, passed, args...)
+	} else {
+		command = rt.Command(container, args...)
+	}
+
+	if !detached {
+		return command
+	}
+
+	kill := rt.Command(container, "sh", "-c", killScript, "sh")
+	forward := append([]string{"sh", "-c", signalScript, "sh", strconv.Itoa(len(kill))}, kill...)
+	return append(forward, command...)
+}
+
+// RenderLauncher renders the launcher for targets keyed by shim name
+func (renderer *Renderer) RenderLauncher(rt runtime.Runtime, container string, targets map[string]string) (string, error) {
+	type entry struct {
+		Name    string
+		Command string
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]entry, len(names))
+	for i, name := range names {
+		entries[i] = entry{
+			Name:    Quote(name),
+			Command: QuoteAll(renderer.Command(rt, container, targets[name])),
+		}
+	}
+
+	var contents strings.Builder
+	launcher := template.Must(template.New("launcher").Parse(launcherTemplate))
+	if err := launcher.Execute(&contents, struct {
+		Container string
+		Cwd       string
+		Start     string
+		KeepAlive string
+		Entries   []entry
+	}{container, CwdScript(rt, renderer.OnUnsharedCwd), StartScript(rt, container, renderer.StartTimeout, "exit"),
+		KeepAliveScript(rt, container, renderer.KeepAlive), entries}); err != nil {
+		return "", err
+	}
+
+	return contents.String(), nil
+}
+
+// Quote returns arg quoted for sh if it contains any special characters
+func Quote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, char := range arg {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' ||
+			char >= '0' && char <= '9' || strings.ContainsRune("_@%+=:,./-", char)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func QuoteAll(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = Quote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// QuoteCmd returns arg quoted for a line of a .cmd file, which passes it
+// on to a program that splits its command line like the C runtime does
+func QuoteCmd(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"^&|<>()") {
+		return arg
+	}
+
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	backslashes := 0
+	for _, char := range arg {
+		switch char {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			// backslashes before a quote escape each other
+			quoted.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+		default:
+			quoted.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		quoted.WriteRune(char)
+	}
+	quoted.WriteString(strings.Repeat(`\`, 2*backslashes))
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
+
+// QuotePowerShell returns arg quoted for PowerShell
+func QuotePowerShell(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+}
+
+// Parse reads back the info of a shim. Returns false if data is not a shim.
+func Parse(data []byte) (Info, bool) {
+	var info Info
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// the comments of .cmd shims are rem lines
+		line := strings.TrimPrefix(scanner.Text(), "rem ")
+
+		switch {
+		case strings.HasPrefix(line, "# btb-format: "):
+			info.Format, _ = strconv.Atoi(strings.TrimPrefix(line, "# btb-format: "))
+		case strings.HasPrefix(line, "# btb-container: "):
+			info.Container = strings.TrimPrefix(line, "# btb-container: ")
+		case strings.HasPrefix(line, "# btb-runtime: "):
+			info.Runtime = strings.TrimPrefix(line, "# btb-runtime: ")
+		case strings.HasPrefix(line, "# btb-target: "):
+			info.Target = strings.TrimPrefix(line, "# btb-target: ")
+		case info.Target == "" && strings.HasPrefix(line, legacyPrefix):
+			fields := strings.Fields(line)
+			if len(fields) >= 5 {
+				info.Container = fields[3]
+				info.Runtime = "toolbox"
+				info.Target = fields[4]
+			}
+		}
+	}
+
+	return info, info.Target != ""
+}
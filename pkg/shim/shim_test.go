@@ -0,0 +1,249 @@
+package shim
+
+import (
+	"btb/pkg/runtime"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"", "''"},
+		{"gcc", "gcc"},
+		{"/usr/bin/g++", "/usr/bin/g++"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+		{"`id`", "'`id`'"},
+		{"a;rm -rf ~", "'a;rm -rf ~'"},
+	}
+
+	for _, test := range tests {
+		if got := Quote(test.arg); got != test.want {
+			t.Errorf("Quote(%q) = %s, want %s", test.arg, got, test.want)
+		}
+	}
+}
+
+// The shell reads every quoted argument back as it was
+func TestQuoteAllShell(t *testing.T) {
+	args := []string{"", "plain", "two words", "it's", `"double"`, "$(id)", "`id`", "back\\slash",
+		"new\nline", "tab\there", "*", "~", "!", "a'b'c"}
+
+	for _, arg := range args {
+		output, err := exec.Command("sh", "-c", "printf '%s\\0' "+QuoteAll([]string{arg, arg})).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := arg + "\x00" + arg + "\x00"; string(output) != want {
+			t.Errorf("sh read %q back as %q", arg, output)
+		}
+	}
+}
+
+func TestQuoteCmd(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"", `""`},
+		{"/usr/bin/gcc", "/usr/bin/gcc"},
+		{"a b", `"a b"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\dir\`, `C:\dir\`},
+		{`C:\my dir\`, `"C:\my dir\\"`},
+		{`a\"b`, `"a\\\"b"`},
+		{"100%", "100%%"},
+		{"a&b", `"a&b"`},
+	}
+
+	for _, test := range tests {
+		if got := QuoteCmd(test.arg); got != test.want {
+			t.Errorf("QuoteCmd(%q) = %s, want %s", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for name, format := range Formats {
+		renderer, err := NewRenderer(format.Template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents, err := renderer.Render(runtime.WSL{}, "Ubuntu", "/usr/bin/gcc")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, ok := Parse([]byte(contents))
+		if want := (Info{"Ubuntu", "wsl", "/usr/bin/gcc", FormatVersion}); !ok || info != want {
+			t.Errorf("%s shim parses as %+v, want %+v:\n%s", name, info, want, contents)
+		}
+		if !strings.Contains(contents, "--exec") {
+			t.Errorf("%s shim does not run the command:\n%s", name, contents)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	legacy := "#!/usr/bin/env bash\n# btb-container: f35\n# btb-runtime: toolbox\n# btb-target: /usr/bin/gcc\n"
+	if info, ok := Parse([]byte(legacy)); !ok || info.Format != 0 {
+		t.Errorf("shim without a format parses as %+v, want format 0", info)
+	}
+
+	if info, ok := Parse([]byte("# btb-format: 1\n" + legacy[len("#!/usr/bin/env bash\n"):])); !ok || info.Format != 1 {
+		t.Errorf("shim with format 1 parses as %+v", info)
+	}
+}
+
+func TestDefaultTemplateSyntax(t *testing.T) {
+	renderer, err := NewRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderer.StartTimeout = 10 * time.Second
+	renderer.HostFallback = true
+	renderer.KeepAlive = time.Minute
+
+	contents, err := renderer.Render(runtime.Podman{}, "f35", "/usr/bin/gcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(contents, "#!/bin/sh\n") {
+		t.Errorf("shim does not run with sh:\n%s", contents)
+	}
+	if output, err := exec.Command("sh", "-n", "-c", contents).CombinedOutput(); err != nil {
+		t.Errorf("shim is not valid sh: %s\n%s", output, contents)
+	}
+}
+
+// Runs commands on the host as if it was a container podman exec runs
+// them in
+type detachedRuntime struct{}
+
+func (detachedRuntime) Name() string {
+	return "detached"
+}
+
+func (detachedRuntime) Command(_ string, args ...string) []string {
+	return args
+}
+
+func (detachedRuntime) Detached() {}
+
+func TestCommandSignals(t *testing.T) {
+	command := Command(detachedRuntime{}, "f35", nil, "sh", "-c", "exit 3")
+	err := exec.Command(command[0], command[1:]...).Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("command exiting with 3 got %v", err)
+	}
+
+	command = Command(detachedRuntime{}, "f35", nil, "sleep", "30")
+	cmd := exec.Command(command[0], command[1:]...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 128+int(syscall.SIGTERM) {
+			t.Errorf("sleep sent TERM got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("TERM was not forwarded to sleep")
+	}
+}
+
+func TestKeepAliveScript(t *testing.T) {
+	runtimeDir := t.TempDir()
+	script := KeepAliveScript(detachedRuntime{}, "f35", 2*time.Second)
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command("sh", "-c", script)
+		cmd.Env = append(os.Environ(), "XDG_RUNTIME_DIR="+runtimeDir)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %s\n%s", err, output, script)
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(runtimeDir, "btb-alive-*-detached-f35.pid"))
+	if len(matches) != 1 {
+		t.Fatalf("no process keeps the container running, found %v", matches)
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(pid, 0); err != nil {
+		t.Errorf("process %d keeping the container running exited: %v", pid, err)
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+}
+
+func TestTranslateScript(t *testing.T) {
+	script := TranslateScript(runtime.Toolbox{}, []string{"/mnt/data=/data"}, "/home/user")
+	output, err := exec.Command("sh", "-c", script+"\nprintf '%s\\n' \"$@\"", "sh",
+		"/mnt/data/x.csv", "/home/user/test.c", "/tmp/test.c", "test.c", "/mnt/database").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "/data/x.csv\n/home/user/test.c\n/run/host/tmp/test.c\ntest.c\n/run/host/mnt/database\n"
+	if string(output) != want {
+		t.Errorf("translated arguments are\n%s\nwant\n%s\nwith\n%s", output, want, script)
+	}
+}
+
+func TestCwdScript(t *testing.T) {
+	home, outside := t.TempDir(), t.TempDir()
+	tests := []struct {
+		policy string
+		dir    string
+		want   string
+		fail   bool
+	}{
+		{"home", home, home, false},
+		{"home", outside, home, false},
+		{"error", outside, "", true},
+		{"error", home, home, false},
+	}
+
+	for _, test := range tests {
+		cmd := exec.Command("sh", "-c", CwdScript(runtime.Toolbox{}, test.policy)+"\npwd")
+		cmd.Dir = test.dir
+		cmd.Env = []string{"HOME=" + home, "PWD=" + test.dir}
+		output, err := cmd.Output()
+		if (err != nil) != test.fail || strings.TrimSpace(string(output)) != test.want {
+			t.Errorf("%s in %s runs in %q (%v), want %q", test.policy, test.dir, output, err, test.want)
+		}
+	}
+
+	if script := CwdScript(runtime.Podman{}, "home"); script != "" {
+		t.Errorf("podman shims check the working directory:\n%s", script)
+	}
+}